@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// requireAdmin gates an admin handler behind the ADMIN_TOKEN bearer token.
+// When ADMIN_TOKEN is unset the admin endpoints are disabled entirely.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := os.Getenv("ADMIN_TOKEN")
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeAPIError(w, &apiError{Status: http.StatusUnauthorized, Code: codeUnauthorized, Message: "missing or invalid admin token"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// adminReloadHandler serves /admin/reload: it re-reads DATASETS_FILE,
+// validates every table against the database and only then swaps the
+// registry in. On any failure the current registry stays in effect.
+func adminReloadHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+
+	path := os.Getenv("DATASETS_FILE")
+	if path == "" {
+		writeAPIError(w, &apiError{Status: http.StatusConflict, Code: codeReloadFailed, Message: "DATASETS_FILE is not set, the built-in dataset cannot be reloaded"})
+		return
+	}
+	reg, err := loadDatasets(path)
+	if err == nil {
+		err = validateDatasets(reg)
+	}
+	if err != nil {
+		log.Printf("Dataset reload rejected, keeping the current registry: %v", err)
+		writeAPIError(w, &apiError{Status: http.StatusUnprocessableEntity, Code: codeReloadFailed, Message: err.Error()})
+		return
+	}
+
+	registry.Store(reg)
+	log.Printf("Reloaded %d dataset(s) from %s", len(reg.byKey), path)
+
+	// Tiles of the previous version can never be served again
+	go pruneTileCache()
+
+	// Summaries of new datasets are needed for /api/datasets and freshness metadata
+	if err := refreshSummaries(); err != nil {
+		log.Printf("WARNING: summary refresh after reload failed: %v", err)
+	}
+	writeJSON(w, struct {
+		Status   string           `json:"status"`
+		Datasets []datasetSummary `json:"datasets"`
+	}{"ok", cachedSummaries()})
+}
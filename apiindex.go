@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// apiEndpoint describes one public endpoint in the /api index.
+type apiEndpoint struct {
+	Path        string   `json:"path"`
+	Methods     []string `json:"methods"`
+	Description string   `json:"description"`
+}
+
+// apiEndpoints lists the public routes registered in main. Keep it in sync
+// when adding one; admin endpoints are deliberately left out.
+var apiEndpoints = []apiEndpoint{
+	{"/api/search", []string{"GET", "HEAD"}, "features near a point, postal code, boundary or bbox"},
+	{"/api/search/multi", []string{"GET"}, "nearest features across several datasets, merged"},
+	{"/api/nearest-per-category", []string{"GET"}, "the nearest feature of each category"},
+	{"/api/hull", []string{"GET"}, "convex hull of the features matching a search"},
+	{"/api/grid", []string{"GET"}, "feature counts per grid cell over a bbox"},
+	{"/api/stats", []string{"GET"}, "min/max/avg/sum of a numeric property over a search"},
+	{"/api/corridor", []string{"GET", "POST"}, "features within a buffer around a GeoJSON LineString"},
+	{"/api/tile/{z}/{x}/{y}.geojson", []string{"GET"}, "raw GeoJSON of one XYZ tile"},
+	{"/tiles/{z}/{x}/{y}.mvt", []string{"GET"}, "Mapbox Vector Tile of one XYZ tile"},
+	{"/api/export", []string{"GET"}, "full dataset as newline-delimited GeoJSON"},
+	{"/api/datasets", []string{"GET"}, "dataset metadata: counts, extents, freshness"},
+	{"/api/errors", []string{"GET"}, "machine-readable error codes and their statuses"},
+	{"/healthz", []string{"GET", "HEAD"}, "liveness probe"},
+	{"/readyz", []string{"GET", "HEAD"}, "readiness probe, per-dataset table checks"},
+}
+
+// apiIndexHandler serves /api: the endpoint list and the active dataset keys,
+// so integrators exploring the API base get something better than the SPA.
+func apiIndexHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", contentTypeJSON)
+
+	reg := currentDatasets()
+	keys := make([]string, 0, len(reg.byKey))
+	for key, ds := range reg.byKey {
+		if canAccess(r, ds) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	writeJSON(w, struct {
+		Status         string        `json:"status"`
+		DefaultDataset string        `json:"default_dataset"`
+		Datasets       []string      `json:"datasets"`
+		Endpoints      []apiEndpoint `json:"endpoints"`
+	}{"ok", reg.def.Key, keys, apiEndpoints})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// apiKeyHeader carries the client API key that unlocks private datasets.
+const apiKeyHeader = "X-API-Key"
+
+// apiKeys are the keys accepted for private datasets, from the comma-separated
+// API_KEYS variable. With none configured, private datasets are unreachable.
+var apiKeys []string
+
+// loadAPIKeys reads API_KEYS.
+func loadAPIKeys() {
+	apiKeys = nil
+	for _, key := range strings.Split(os.Getenv("API_KEYS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			apiKeys = append(apiKeys, key)
+		}
+	}
+}
+
+// hasValidAPIKey reports whether r carries one of apiKeys.
+func hasValidAPIKey(r *http.Request) bool {
+	got := []byte(r.Header.Get(apiKeyHeader))
+	if len(got) == 0 {
+		return false
+	}
+	for _, key := range apiKeys {
+		if subtle.ConstantTimeCompare(got, []byte(key)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// canAccess reports whether r may read ds: public datasets are open to
+// everyone, private ones need a valid API key.
+func canAccess(r *http.Request, ds *dataset) bool {
+	return ds.public() || hasValidAPIKey(r)
+}
+
+// readsDataset reports whether the request at path queries a dataset, and so
+// falls back to the default one when it names none. Health checks, the SPA,
+// the API index and listings, and admin endpoints (which have their own
+// token) do not.
+func readsDataset(path string) bool {
+	path = strings.TrimSuffix(path, "/")
+	switch path {
+	case "/api", "/api/datasets", "/api/errors":
+		return false
+	}
+	return strings.HasPrefix(path, "/api/") || strings.HasPrefix(path, "/tiles/")
+}
+
+// withDatasetAccess rejects requests naming a private dataset (in `dataset`
+// or the `datasets` list) without a valid API key, before any handler or
+// query slot is involved. Unknown keys pass through so the handler can
+// report them. CORS preflights carry no key and are let through, and so is
+// every route that does not read a dataset.
+// Deprecated aliases are flagged with Deprecation and Warning headers.
+func withDatasetAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions || !readsDataset(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		q := r.URL.Query()
+		keys := []string{q.Get("dataset")}
+		if raw := q.Get("datasets"); raw != "" {
+			keys = strings.Split(raw, ",")
+		}
+		reg := currentDatasets()
+		for _, key := range keys {
+			ds := reg.def
+			if key = strings.TrimSpace(key); key != "" {
+				var alias bool
+				if ds, alias = reg.resolve(key); alias {
+					w.Header().Set("Deprecation", "true")
+					w.Header().Add("Warning", fmt.Sprintf(`299 - "dataset %q is deprecated, use %q"`, key, ds.Key))
+				}
+			}
+			if ds != nil && !canAccess(r, ds) {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+				writeAPIError(w, &apiError{Status: http.StatusForbidden, Code: codeForbidden,
+					Message: "dataset " + ds.Key + " is private, a valid " + apiKeyHeader + " header is required"})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDatasetAccessWithPrivateDefault(t *testing.T) {
+	private := false
+	ds := &dataset{Key: "secret", Table: "secret", Public: &private}
+	reg := &datasetRegistry{byKey: map[string]*dataset{ds.Key: ds}, aliases: map[string]*dataset{}, def: ds}
+	prev := registry.Swap(reg)
+	t.Cleanup(func() { registry.Store(prev) })
+
+	handler := withDatasetAccess(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tc := range []struct {
+		method, target string
+		want           int
+	}{
+		{http.MethodGet, "/healthz", http.StatusOK},
+		{http.MethodGet, "/readyz", http.StatusOK},
+		{http.MethodGet, "/", http.StatusOK},
+		{http.MethodGet, "/api", http.StatusOK},
+		{http.MethodGet, "/api/", http.StatusOK},
+		{http.MethodGet, "/api/datasets", http.StatusOK},
+		{http.MethodGet, "/api/errors", http.StatusOK},
+		{http.MethodOptions, "/api/search?dataset=secret", http.StatusOK},
+		{http.MethodGet, "/api/search?lat=0&lng=0", http.StatusForbidden},
+		{http.MethodGet, "/api/search?dataset=secret&lat=0&lng=0", http.StatusForbidden},
+		{http.MethodGet, "/tiles/1/0/0", http.StatusForbidden},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.target, nil))
+		if rec.Code != tc.want {
+			t.Errorf("%s %s answered %d, want %d", tc.method, tc.target, rec.Code, tc.want)
+		}
+	}
+}
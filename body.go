@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// defaultMaxBodyBytes caps request bodies; override with MAX_BODY_BYTES.
+const defaultMaxBodyBytes = 1 << 20
+
+var maxBodyBytes = defaultMaxBodyBytes
+
+// readRequestBody reads the whole body of r, rejecting bodies larger than
+// maxBodyBytes with a 413 before anything is decoded. Every endpoint that
+// accepts a body goes through here.
+func readRequestBody(w http.ResponseWriter, r *http.Request) ([]byte, *apiError) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBodyBytes)))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, &apiError{Status: http.StatusRequestEntityTooLarge, Code: codeBodyTooLarge,
+				Message: fmt.Sprintf("request body exceeds the %d byte limit", tooLarge.Limit)}
+		}
+		return nil, badRequest(codeInvalidParameter, "reading request body: %s", err)
+	}
+	return body, nil
+}
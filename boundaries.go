@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+// boundarySource describes a polygon table (council districts, ZIP codes, ...)
+// that a dataset's features can be joined against.
+type boundarySource struct {
+	Table      string `json:"table"`
+	IDColumn   string `json:"id_column"`
+	GeomColumn string `json:"geometry_column"`
+	SRID       int    `json:"srid"` // defaults to 4326
+
+	idType string // SQL type of IDColumn, detected by validateDatasets
+}
+
+// geom returns the boundary geometry column in WGS84, qualified by alias b.
+func (b *boundarySource) geom() string {
+	col := "b." + pq.QuoteIdentifier(b.GeomColumn)
+	if b.SRID == 0 || b.SRID == 4326 {
+		return col
+	}
+	return fmt.Sprintf("ST_Transform(%s, 4326)", col)
+}
+
+// idParam casts the placeholder arg to the id column's type, so the lookup
+// compares the column as stored and can use its index.
+func (b *boundarySource) idParam(arg string) string {
+	if b.idType == "" {
+		return arg
+	}
+	return fmt.Sprintf("CAST(%s AS %s)", arg, b.idType)
+}
+
+// withinExpr returns a predicate true when featureGeom lies inside the boundary
+// whose id is bound at placeholder idArg.
+func (b *boundarySource) withinExpr(featureGeom, idArg string) string {
+	return fmt.Sprintf(`EXISTS (
+					SELECT 1 FROM %s b
+					WHERE b.%s = %s AND ST_Within(%s, %s)
+				)`, quoteTable(b.Table), pq.QuoteIdentifier(b.IDColumn), b.idParam(idArg), featureGeom, b.geom())
+}
+
+// boundaryCenter returns a point guaranteed to lie inside the boundary, used as
+// the distance reference when a boundary search has no explicit lat/lng.
+func boundaryCenter(ctx context.Context, b *boundarySource, id string) (lat, lng float64, apiErr *apiError) {
+	lat, lng, err := pointOnBoundary(ctx, b, id)
+	if err == sql.ErrNoRows {
+		return 0, 0, &apiError{Status: http.StatusNotFound, Code: codeBoundaryNotFound, Message: fmt.Sprintf("boundary %q not found", id)}
+	} else if err != nil {
+		return 0, 0, queryError(ctx, err)
+	}
+	return lat, lng, nil
+}
+
+// postalCodeCenter returns a point inside the polygon of a postal code, the
+// center of a zip search.
+func postalCodeCenter(ctx context.Context, b *boundarySource, zip string) (lat, lng float64, apiErr *apiError) {
+	lat, lng, err := pointOnBoundary(ctx, b, zip)
+	if err == sql.ErrNoRows {
+		return 0, 0, &apiError{Status: http.StatusNotFound, Code: codeZipNotFound, Message: fmt.Sprintf("postal code %q not found", zip)}
+	} else if err != nil {
+		return 0, 0, queryError(ctx, err)
+	}
+	return lat, lng, nil
+}
+
+// pointOnBoundary returns ST_PointOnSurface of the polygon with the given id,
+// a point guaranteed to lie inside it even for concave shapes.
+// It returns sql.ErrNoRows when no polygon has that id.
+func pointOnBoundary(ctx context.Context, b *boundarySource, id string) (lat, lng float64, err error) {
+	queryStr := fmt.Sprintf(
+		`SELECT ST_Y(ST_PointOnSurface(%s)), ST_X(ST_PointOnSurface(%s))
+		FROM %s b
+		WHERE b.%s = %s
+		LIMIT 1;
+		`, b.geom(), b.geom(), quoteTable(b.Table), pq.QuoteIdentifier(b.IDColumn), b.idParam("$1"))
+
+	err = readDB.QueryRowContext(ctx, queryStr, id).Scan(&lat, &lng)
+	return lat, lng, err
+}
+
+// detectIDType fills in b.idType from the catalog, so ids from the query
+// string can be cast to the column's type rather than the column to text.
+func detectIDType(key string, b *boundarySource) error {
+	err := db.QueryRow(
+		`SELECT format_type(atttypid, atttypmod) FROM pg_attribute
+		WHERE attrelid = $1::regclass AND attname = $2`,
+		quoteTable(b.Table), b.IDColumn).Scan(&b.idType)
+	if err != nil {
+		return fmt.Errorf("dataset %q: detecting the type of %s.%s: %w", key, b.Table, b.IDColumn, err)
+	}
+	return nil
+}
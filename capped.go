@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Capped-search reporting. A high share of searches hitting their limit means
+// users are not seeing complete results and should get pagination or tiles.
+const (
+	defaultCappedReportInterval = 15 * time.Minute
+	cappedWarnRatio             = 0.2 // above this share the report is logged as a warning
+)
+
+// cappedSearches counts limited searches since the last report.
+var cappedSearches struct {
+	total  atomic.Int64
+	capped atomic.Int64
+}
+
+// setResultCapped records whether a limited search had more matches than it
+// returned, both on the request's access log line and in the periodic report.
+func setResultCapped(r *http.Request, capped bool) {
+	if s := statsFromContext(r.Context()); s != nil {
+		s.Capped = &capped
+	}
+	cappedSearches.total.Add(1)
+	if capped {
+		cappedSearches.capped.Add(1)
+	}
+}
+
+// startCappedReporter logs the share of capped searches every interval and
+// resets the counters. Intervals without searches are skipped.
+func startCappedReporter(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			total, capped := cappedSearches.total.Swap(0), cappedSearches.capped.Swap(0)
+			if total == 0 {
+				continue
+			}
+			ratio := float64(capped) / float64(total)
+			level := slog.LevelInfo
+			if ratio > cappedWarnRatio {
+				level = slog.LevelWarn
+			}
+			slog.Log(context.Background(), level, "capped searches",
+				"searches", total, "capped", capped, "ratio", ratio, "interval", interval.String())
+		}
+	}()
+}
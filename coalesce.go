@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// flightGroup coalesces concurrent identical calls: while a call for a key
+// is in flight, later callers with the same key wait for its result instead
+// of running their own. It adds context handling on top of singleflight.
+type flightGroup[T any] struct {
+	group singleflight.Group
+
+	mu      sync.Mutex
+	flights map[string]*flight // callers of each in-flight key
+}
+
+// flight is the shared context of one in-flight key and its waiting callers.
+type flight struct {
+	ctx     context.Context
+	cancel  context.CancelFunc // aborts the call once every waiter has gone
+	waiters int
+}
+
+// do runs fn once per key among concurrent callers and hands every caller
+// the same result. shared reports whether the result went to more than one
+// caller.
+//
+// fn runs under a context detached from any single caller (keeping its values
+// and deadline), so one client disconnecting does not fail the query for the
+// others. Each caller still stops waiting as soon as its own ctx is done, and
+// the query itself is canceled once no caller is left waiting for it.
+func (g *flightGroup[T]) do(ctx context.Context, key string, fn func(context.Context) (T, error)) (val T, shared bool, err error) {
+	g.mu.Lock()
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	f, ok := g.flights[key]
+	if ok {
+		f.waiters++
+	} else {
+		f = &flight{waiters: 1}
+		if deadline, ok := ctx.Deadline(); ok {
+			f.ctx, f.cancel = context.WithDeadline(context.WithoutCancel(ctx), deadline)
+		} else {
+			f.ctx, f.cancel = context.WithCancel(context.WithoutCancel(ctx))
+		}
+		g.flights[key] = f
+	}
+	g.mu.Unlock()
+	defer g.leave(key, f)
+
+	ch := g.group.DoChan(key, func() (v any, err error) {
+		defer func() {
+			// singleflight re-panics in its own goroutine, which would take the server down
+			if p := recover(); p != nil {
+				err = fmt.Errorf("coalesced call panicked: %v", p)
+			}
+		}()
+		return fn(f.ctx)
+	})
+	select {
+	case res := <-ch:
+		val, _ = res.Val.(T)
+		return val, res.Shared, res.Err
+	case <-ctx.Done():
+		return val, ok, ctx.Err()
+	}
+}
+
+// leave drops a caller of f. The last one cancels the call, and makes
+// singleflight forget it so new callers start afresh rather than joining a
+// call that is being aborted.
+func (g *flightGroup[T]) leave(key string, f *flight) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	f.waiters--
+	if f.waiters > 0 {
+		return
+	}
+	f.cancel()
+	if g.flights[key] == f {
+		delete(g.flights, key)
+		g.group.Forget(key)
+	}
+}
+
+// searchFlights coalesces identical searches, see getGeoJSONFromDatabase.
+var searchFlights flightGroup[searchResult]
+
+// flightKey identifies a query by its SQL text and bound arguments, which
+// together capture everything (dataset, filters, center, limit) that can
+// change its result. Arguments are keyed by the value the driver sends, so
+// a pq.Array compares by its elements rather than its address. ok is false
+// when an argument has no driver value; such a query is not coalesced.
+func flightKey(query string, args []any) (key string, ok bool) {
+	var b strings.Builder
+	b.WriteString(query)
+	for _, arg := range args {
+		v, err := driver.DefaultParameterConverter.ConvertValue(arg)
+		if err != nil {
+			return "", false
+		}
+		fmt.Fprintf(&b, "\x00%T:%v", v, v)
+	}
+	return b.String(), true
+}
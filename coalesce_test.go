@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestFlightGroupCoalescesConcurrentCalls(t *testing.T) {
+	var g flightGroup[int]
+	var calls atomic.Int32
+	started, release := make(chan struct{}), make(chan struct{})
+	fetch := func(ctx context.Context) (int, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		return 42, nil
+	}
+
+	const callers = 8
+	type result struct {
+		val    int
+		shared bool
+		err    error
+	}
+	results := make(chan result, callers)
+	go func() {
+		val, shared, err := g.do(context.Background(), "k", fetch)
+		results <- result{val, shared, err}
+	}()
+	<-started
+	var wg sync.WaitGroup
+	for range callers - 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, shared, err := g.do(context.Background(), "k", fetch)
+			results <- result{val, shared, err}
+		}()
+	}
+	// Let the others join the call in flight before it completes; a caller
+	// is counted just before it reaches singleflight, hence the grace period
+	waitFor(t, func() bool { return waiters(&g, "k") == callers })
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for range callers {
+		r := <-results
+		if r.err != nil || r.val != 42 || !r.shared {
+			t.Errorf("caller got (%d, shared %v, %v), want (42, shared true, nil)", r.val, r.shared, r.err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("fetch ran %d times, want once", n)
+	}
+	if n := waiters(&g, "k"); n != 0 {
+		t.Errorf("%d waiters left after every caller returned", n)
+	}
+}
+
+func TestFlightGroupCallerCancel(t *testing.T) {
+	var g flightGroup[int]
+	started, release := make(chan struct{}), make(chan struct{})
+	fetchErr := make(chan error, 1)
+	fetch := func(ctx context.Context) (int, error) {
+		close(started)
+		select {
+		case <-release:
+			fetchErr <- ctx.Err()
+			return 42, nil
+		case <-ctx.Done():
+			fetchErr <- ctx.Err()
+			return 0, ctx.Err()
+		}
+	}
+
+	leaverCtx, leave := context.WithCancel(context.Background())
+	leaverDone := make(chan error, 1)
+	go func() {
+		_, _, err := g.do(leaverCtx, "k", fetch)
+		leaverDone <- err
+	}()
+	<-started
+	stayerDone := make(chan int, 1)
+	go func() {
+		val, _, err := g.do(context.Background(), "k", fetch)
+		if err != nil {
+			t.Errorf("remaining caller: %v", err)
+		}
+		stayerDone <- val
+	}()
+	waitFor(t, func() bool { return waiters(&g, "k") == 2 })
+
+	// The caller that started the query goes away; the other still gets the result
+	leave()
+	select {
+	case err := <-leaverDone:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("canceled caller got %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("canceled caller kept waiting")
+	}
+	close(release)
+	if val := <-stayerDone; val != 42 {
+		t.Errorf("remaining caller got %d, want 42", val)
+	}
+	if err := <-fetchErr; err != nil {
+		t.Errorf("fetch context was done (%v) while a caller was still waiting", err)
+	}
+}
+
+func TestFlightGroupLastCallerCancelAbortsFetch(t *testing.T) {
+	var g flightGroup[int]
+	started := make(chan struct{})
+	fetchErr := make(chan error, 1)
+	fetch := func(ctx context.Context) (int, error) {
+		close(started)
+		select {
+		case <-ctx.Done():
+			fetchErr <- ctx.Err()
+		case <-time.After(2 * time.Second):
+			fetchErr <- nil
+		}
+		return 0, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go g.do(ctx, "k", fetch)
+	<-started
+	cancel()
+	if err := <-fetchErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("fetch context after its only caller left: %v, want context.Canceled", err)
+	}
+
+	// A new caller starts afresh instead of joining the aborted call
+	val, _, err := g.do(context.Background(), "k", func(context.Context) (int, error) { return 7, nil })
+	if err != nil || val != 7 {
+		t.Errorf("call after the abort got (%d, %v), want (7, nil)", val, err)
+	}
+}
+
+func TestFlightKey(t *testing.T) {
+	const query = "SELECT $1, $2"
+	key := func(args ...any) string {
+		t.Helper()
+		k, ok := flightKey(query, args)
+		if !ok {
+			t.Fatalf("flightKey(%v) not coalescable", args)
+		}
+		return k
+	}
+
+	if key("a", pq.Array([]string{"x", "y"})) != key("a", pq.Array([]string{"x", "y"})) {
+		t.Error("equal pq.Array arguments give different keys")
+	}
+	for _, pair := range [][2][]any{
+		{{"a", pq.Array([]string{"x", "y"})}, {"a", pq.Array([]string{"x", "z"})}},
+		{{"a", pq.Array([]string{"x", "y"})}, {"a", pq.Array([]string{"y", "x"})}},
+		{{"a", pq.Array([]int64{1, 2})}, {"a", pq.Array([]int64{1, 3})}},
+		{{int64(1), "a"}, {"1", "a"}},
+		{{1.5, "a"}, {2.5, "a"}},
+		{{"a", nil}, {"a", ""}},
+	} {
+		if key(pair[0]...) == key(pair[1]...) {
+			t.Errorf("arguments %v and %v give the same key", pair[0], pair[1])
+		}
+	}
+	if _, ok := flightKey(query, []any{struct{}{}}); ok {
+		t.Error("an argument without a driver value was coalesced")
+	}
+}
+
+// waiters returns the number of callers waiting on key.
+func waiters(g *flightGroup[int], key string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f := g.flights[key]; f != nil {
+		return f.waiters
+	}
+	return 0
+}
+
+// waitFor polls cond until it holds, failing t after a while.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for callers")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
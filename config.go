@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envDuration reads a time.Duration (e.g. "5m") from the environment,
+// falling back to def when unset or malformed.
+func envDuration(name string, def time.Duration) time.Duration {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		log.Printf("WARNING: ignoring invalid %s=%q, using %s", name, raw, def)
+		return def
+	}
+	return d
+}
+
+// envInt reads a positive integer from the environment, falling back to def
+// when unset or malformed.
+func envInt(name string, def int) int {
+	return envIntAtLeast(name, def, 1)
+}
+
+// envNonNegativeInt is envInt for settings where 0 is meaningful, such as
+// "no decimals".
+func envNonNegativeInt(name string, def int) int {
+	return envIntAtLeast(name, def, 0)
+}
+
+// envIntAtLeast reads an integer no smaller than lowest from the environment,
+// falling back to def when unset or malformed.
+func envIntAtLeast(name string, def, lowest int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < lowest {
+		log.Printf("WARNING: ignoring invalid %s=%q, using %d", name, raw, def)
+		return def
+	}
+	return n
+}
+
+// envName turns a name like "search" into its environment variable form ("SEARCH").
+func envName(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Corridor search limits. The buffer cap keeps ST_DWithin selective enough for
+// the spatial index; the vertex cap bounds the cost of parsing the route.
+const (
+	defaultCorridorBuffer = 500
+	maxCorridorBuffer     = 5000
+	maxCorridorVertices   = 10000
+)
+
+// corridorParams holds the validated parameters of a corridor search.
+type corridorParams struct {
+	Dataset      *dataset
+	Line         string // validated GeoJSON LineString in WGS84
+	BufferMeters int
+	Unit         string // key of distanceUnits the distance property is returned in
+	Limit        int
+	Categories   []string
+}
+
+// apiCorridorHandler serves /api/corridor: features within a buffer around a
+// route, nearest to the route first.
+// The LineString is passed as GeoJSON in the `line` parameter, or as the
+// request body of a POST for routes too long for a URL.
+func apiCorridorHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", contentTypeJSON)
+
+	q := r.URL.Query()
+	line := []byte(q.Get("line"))
+	if r.Method == http.MethodPost {
+		body, apiErr := readRequestBody(w, r)
+		if apiErr != nil {
+			writeAPIError(w, apiErr)
+			return
+		}
+		line = body
+	}
+
+	params, apiErr := parseCorridorParams(q, line)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	result, err := getCorridorFromDatabase(r.Context(), params)
+	if err != nil {
+		writeAPIError(w, queryError(r.Context(), err))
+		return
+	}
+	setResultCount(r, result.Count)
+	setResultCapped(r, result.Capped)
+
+	writeBody(w, fmt.Sprintf(`{"status": "ok", "features": %s, "capped": %t}`, result.Features, result.Capped))
+}
+
+// parseCorridorParams validates the corridor query string and route geometry.
+func parseCorridorParams(q url.Values, line []byte) (corridorParams, *apiError) {
+	p := corridorParams{BufferMeters: defaultCorridorBuffer, Limit: defaultSearchLimit}
+	var apiErr *apiError
+
+	if p.Dataset, apiErr = lookupDataset(q.Get("dataset")); apiErr != nil {
+		return p, apiErr
+	}
+	if p.Line, apiErr = parseLineString(line); apiErr != nil {
+		return p, apiErr
+	}
+	if p.Unit, apiErr = parseUnit(q); apiErr != nil {
+		return p, apiErr
+	}
+
+	var err error
+	if raw := q.Get("buffer"); raw != "" {
+		if p.BufferMeters, err = strconv.Atoi(raw); err != nil || p.BufferMeters < 1 || p.BufferMeters > maxCorridorBuffer {
+			return p, badRequest(codeInvalidBuffer, "buffer must be an integer between 1 and %d meters, got %q", maxCorridorBuffer, raw)
+		}
+	}
+	if raw := q.Get("limit"); raw != "" {
+		if p.Limit, err = strconv.Atoi(raw); err != nil || p.Limit < 1 || p.Limit > maxSearchLimit {
+			return p, badRequest(codeInvalidLimit, "limit must be an integer between 1 and %d, got %q", maxSearchLimit, raw)
+		}
+	}
+
+	if raw, ok := q["category"]; ok {
+		if p.Dataset.CategoryColumn == "" {
+			return p, badRequest(codeInvalidCategory, "dataset %q does not support category filters", p.Dataset.Key)
+		}
+		for _, c := range strings.Split(strings.Join(raw, ","), ",") {
+			if c = strings.TrimSpace(c); c == "" {
+				return p, badRequest(codeInvalidCategory, "category list must not contain empty values")
+			}
+			p.Categories = append(p.Categories, c)
+		}
+	}
+	return p, nil
+}
+
+// parseLineString checks that raw is a GeoJSON LineString with valid WGS84
+// positions and returns it re-encoded, so PostGIS only ever sees vetted input.
+func parseLineString(raw []byte) (string, *apiError) {
+	if len(raw) == 0 {
+		return "", badRequest(codeInvalidGeometry, "missing route: pass a GeoJSON LineString as `line` or as the POST body")
+	}
+	var g struct {
+		Type        string      `json:"type"`
+		Coordinates [][]float64 `json:"coordinates"`
+	}
+	if err := json.Unmarshal(raw, &g); err != nil {
+		return "", badRequest(codeInvalidGeometry, "route is not valid GeoJSON: %s", err)
+	}
+	if g.Type != "LineString" {
+		return "", badRequest(codeInvalidGeometry, "route must be a GeoJSON LineString, got type %q", g.Type)
+	}
+	if len(g.Coordinates) < 2 || len(g.Coordinates) > maxCorridorVertices {
+		return "", badRequest(codeInvalidGeometry, "route must have between 2 and %d positions, got %d", maxCorridorVertices, len(g.Coordinates))
+	}
+	for i, pos := range g.Coordinates {
+		if apiErr := checkPosition(i, pos); apiErr != nil {
+			return "", apiErr
+		}
+	}
+
+	out, _ := json.Marshal(g)
+	return string(out), nil
+}
+
+// checkPosition validates the i-th GeoJSON position of an input geometry.
+func checkPosition(i int, pos []float64) *apiError {
+	if len(pos) < 2 {
+		return badRequest(codeInvalidGeometry, "position %d needs a longitude and a latitude", i)
+	}
+	if pos[0] < -180 || pos[0] > 180 || pos[1] < -90 || pos[1] > 90 {
+		return badRequest(codeInvalidGeometry, "position %d [%v, %v] is out of range, expected [lng, lat]", i, pos[0], pos[1])
+	}
+	return nil
+}
+
+// maxPolygonVertices bounds the size of polygon parameters such as exclude_polygon.
+const maxPolygonVertices = 10000
+
+// parsePolygon checks that raw is a GeoJSON Polygon or MultiPolygon with
+// closed rings of valid WGS84 positions and returns it re-encoded.
+func parsePolygon(name string, raw []byte) (string, *apiError) {
+	var g struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	}
+	if err := json.Unmarshal(raw, &g); err != nil {
+		return "", badRequest(codeInvalidGeometry, "%s is not valid GeoJSON: %s", name, err)
+	}
+
+	var polygons [][][][]float64
+	switch g.Type {
+	case "Polygon":
+		var rings [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &rings); err != nil {
+			return "", badRequest(codeInvalidGeometry, "%s has invalid Polygon coordinates: %s", name, err)
+		}
+		polygons = [][][][]float64{rings}
+	case "MultiPolygon":
+		if err := json.Unmarshal(g.Coordinates, &polygons); err != nil {
+			return "", badRequest(codeInvalidGeometry, "%s has invalid MultiPolygon coordinates: %s", name, err)
+		}
+	default:
+		return "", badRequest(codeInvalidGeometry, "%s must be a GeoJSON Polygon or MultiPolygon, got type %q", name, g.Type)
+	}
+
+	vertices := 0
+	for _, rings := range polygons {
+		if len(rings) == 0 {
+			return "", badRequest(codeInvalidGeometry, "%s has a polygon without rings", name)
+		}
+		for _, ring := range rings {
+			// A linear ring needs at least 4 positions, the last repeating the first
+			if len(ring) < 4 || ring[0][0] != ring[len(ring)-1][0] || ring[0][1] != ring[len(ring)-1][1] {
+				return "", badRequest(codeInvalidGeometry, "%s has a ring that is not closed or has fewer than 4 positions", name)
+			}
+			for _, pos := range ring {
+				if apiErr := checkPosition(vertices, pos); apiErr != nil {
+					return "", apiErr
+				}
+				vertices++
+			}
+		}
+	}
+	if vertices > maxPolygonVertices {
+		return "", badRequest(codeInvalidGeometry, "%s has %d positions, at most %d are allowed", name, vertices, maxPolygonVertices)
+	}
+
+	out, _ := json.Marshal(struct {
+		Type        string          `json:"type"`
+		Coordinates [][][][]float64 `json:"coordinates"`
+	}{"MultiPolygon", polygons})
+	return string(out), nil
+}
+
+// getCorridorFromDatabase returns the features within p.BufferMeters of the
+// route, nearest first, in the same shape as getGeoJSONFromDatabase.
+func getCorridorFromDatabase(ctx context.Context, p corridorParams) (searchResult, error) {
+	ds := p.Dataset
+	du := distanceUnits[p.Unit]
+	var args queryArgs
+	line := fmt.Sprintf("ST_SetSRID(ST_GeomFromGeoJSON(%s), 4326)::geography", args.add(p.Line))
+
+	where := []string{
+		ds.geomCol() + " IS NOT NULL",
+		fmt.Sprintf("ST_DWithin(%s::geography, %s, %s)", ds.geom(), line, args.add(p.BufferMeters)),
+	}
+	if len(p.Categories) > 0 {
+		where = append(where, fmt.Sprintf("%s = ANY(%s)",
+			pq.QuoteIdentifier(ds.CategoryColumn), args.add(pq.Array(p.Categories))))
+	}
+	limit := args.add(p.Limit)
+
+	var queryStr = fmt.Sprintf(
+		`SELECT COALESCE(jsonb_agg(t.feature ORDER BY t.n) FILTER (WHERE t.n <= %[1]s), '[]'::jsonb), LEAST(count(*), %[1]s), count(*) > %[1]s
+		FROM (
+			SELECT jsonb_build_object(
+				'type', 'Feature',
+				'geometry', ST_AsGeoJSON(%[2]s)::jsonb,
+				'properties', (%[3]s) || jsonb_build_object(%[8]s, round((row.distance_m / %[9]v)::numeric, %[4]d))
+			) AS feature, ROW_NUMBER() OVER (ORDER BY distance_m) AS n
+			FROM (
+				SELECT *, ST_Distance(%[2]s::geography, %[5]s) AS distance_m
+				FROM %[6]s
+				WHERE %[7]s
+				ORDER BY distance_m
+				LIMIT %[1]s + 1
+			) row
+		) t;
+		`, limit, ds.geom(), ds.propertiesExpr("row"), distancePrecision, line, ds.quotedTable(),
+		strings.Join(where, "\n\t\t\t\tAND "), pq.QuoteLiteral(du.column), du.divisor)
+
+	var result searchResult
+	logQuery(ctx, "corridor", queryStr, args)
+	if err := readDB.QueryRowContext(ctx, queryStr, args...).Scan(&result.Features, &result.Count, &result.Capped); err != nil {
+		return searchResult{}, fmt.Errorf("error scanning row: %w", err)
+	}
+	return result, nil
+}
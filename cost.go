@@ -0,0 +1,108 @@
+package main
+
+// defaultQueryCostBudget is the highest estimated cost a search may have
+// (QUERY_COST_BUDGET overrides it, 0 disables the check). The default admits
+// every app.js request with a wide margin: a 10 km, 25 result search costs 27.
+const defaultQueryCostBudget = 1000
+
+var queryCostBudget = defaultQueryCostBudget
+
+// Weights of the cost estimate. They are deliberately coarse: the point is
+// one tunable policy over the knobs that make a search expensive, not a
+// prediction of its run time.
+const (
+	costPerSquareKm   = 0.02 // rows scanned grow with the searched area, i.e. radius²
+	costPerResult     = 1    // every returned row is serialized (twice for shapes, see below)
+	costPerFilter     = 10   // category, where, has and exclude_polygon predicates
+	costPerPostStep   = 50   // group_by, dedupe_by, per_category_limit, formatted, auto_expand
+	costAreaSearch    = 100  // within_boundary or bbox without a radius: bounded by an area we cannot size here
+	costShapeMultiple = 2    // line and polygon datasets with full geometries serialize heavier rows
+)
+
+// queryCost is the estimated cost of a search, broken down by factor so a
+// rejected client can see what to reduce.
+type queryCost struct {
+	Total   int            `json:"cost"`
+	Budget  int            `json:"budget"`
+	Factors map[string]int `json:"factors"`
+}
+
+// largest returns the factor contributing most to the cost.
+func (c queryCost) largest() string {
+	var name string
+	for f, v := range c.Factors {
+		if name == "" || v > c.Factors[name] || (v == c.Factors[name] && f < name) {
+			name = f
+		}
+	}
+	return name
+}
+
+// costHints tell the client how to lower each factor.
+var costHints = map[string]string{
+	"radius":  "use a smaller radius or max_distance",
+	"area":    "add a radius to the boundary or bbox search",
+	"limit":   "request fewer results with limit",
+	"filters": "use fewer category, where, has or exclude_polygon filters",
+	"post":    "drop group_by, dedupe_by, per_category_limit, formatted or auto_expand",
+}
+
+// estimateSearchCost scores p from its radius, limit, filters and output
+// options.
+func estimateSearchCost(p searchParams) queryCost {
+	c := queryCost{Budget: queryCostBudget, Factors: map[string]int{}}
+
+	radiusKm := float64(p.RadiusMeters) / 1000
+	if p.AutoExpand && p.RadiusMeters > 0 {
+		// The worst case is the fully widened radius
+		radiusKm = float64(max(p.RadiusMeters, autoExpandMaxRadius)) / 1000
+	}
+	switch {
+	case p.RadiusMeters > 0:
+		c.Factors["radius"] = int(radiusKm * radiusKm * costPerSquareKm)
+	case p.WithinBoundary != "" || p.BBox != nil:
+		c.Factors["area"] = costAreaSearch
+	}
+
+	perResult := costPerResult
+	if p.Dataset.extended() && !p.Minimal && p.IncludeGeometry && p.Format != formatPBF && p.Format != formatFlat {
+		perResult *= costShapeMultiple
+	}
+	c.Factors["limit"] = p.Limit * perResult
+
+	filters := len(p.Categories) + len(p.Where) + len(p.Has)
+	if p.ExcludePolygon != "" {
+		filters++
+	}
+	c.Factors["filters"] = filters * costPerFilter
+
+	steps := 0
+	for _, on := range []bool{p.GroupBy != "", p.DedupeBy != "", p.PerCategoryLimit > 0, p.Formatted, p.AutoExpand} {
+		if on {
+			steps++
+		}
+	}
+	c.Factors["post"] = steps * costPerPostStep
+
+	for _, v := range c.Factors {
+		c.Total += v
+	}
+	return c
+}
+
+// checkQueryCost rejects searches whose estimated cost exceeds the budget,
+// naming the factor that contributes most.
+func checkQueryCost(p searchParams) *apiError {
+	if queryCostBudget <= 0 {
+		return nil
+	}
+	c := estimateSearchCost(p)
+	if c.Total <= c.Budget {
+		return nil
+	}
+	name := c.largest()
+	apiErr := badRequest(codeQueryTooExpensive, "estimated query cost %d exceeds the budget of %d; the largest factor is %s (%d): %s",
+		c.Total, c.Budget, name, c.Factors[name], costHints[name])
+	apiErr.Details = c
+	return apiErr
+}
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// dataset describes a PostGIS table that the API can search.
+// Descriptors are loaded from DATASETS_FILE (a JSON array) or fall back to defaultDataset.
+type dataset struct {
+	Key               string                       `json:"key"`                 // public identifier used by clients
+	DisplayName       string                       `json:"display_name"`        // human readable layer name
+	Table             string                       `json:"table"`               // PostGIS table, optionally schema-qualified
+	GeomColumn        string                       `json:"geometry_column"`     // defaults to wkb_geometry (ogr2ogr)
+	IDColumn          string                       `json:"id_column"`           // defaults to ogc_fid (ogr2ogr)
+	SRID              int                          `json:"srid"`                // detected with Find_SRID at startup when omitted
+	CategoryColumn    string                       `json:"category_column"`     // column matched by the `category` filter, empty if unsupported
+	Filters           []string                     `json:"filters"`             // columns clients may filter on
+	Boundaries        *boundarySource              `json:"boundaries"`          // polygons for within_boundary searches, optional
+	PostalCodes       *boundarySource              `json:"postal_codes"`        // ZIP polygons for zip searches, id_column holds the code, optional
+	DefaultOrder      []orderTerm                  `json:"default_order"`       // search order without an explicit sort, distance first when empty
+	UpdatedColumn     string                       `json:"updated_at_column"`   // timestamp column behind data_updated_at, optional
+	FeaturedColumn    string                       `json:"featured_column"`     // boolean/priority column used by boost=true, optional
+	PopularityColumn  string                       `json:"popularity_column"`   // numeric score behind sort=popularity, optional
+	PropertyTypes     map[string]string            `json:"property_types"`      // column -> JSON type cast (see propertyTypeCasts), optional
+	Public            *bool                        `json:"public"`              // false requires an API key (see auth.go), public when omitted
+	Aliases           []string                     `json:"aliases"`             // former keys still accepted (deprecated) after a rename
+	LocalizedFields   map[string]map[string]string `json:"localized_fields"`    // property -> language -> column, selected by `lang`, optional
+	DefaultLanguage   string                       `json:"default_language"`    // language used without `lang` or when its column is empty
+	GeometryType      string                       `json:"geometry_type"`       // point (default), line or polygon; see geometryTypes
+	MaxPropertyLength int                          `json:"max_property_length"` // longer string properties are truncated with an ellipsis, 0 for no limit
+	RepairGeometry    bool                         `json:"repair_geometry"`     // wrap the geometry in ST_MakeValid in every query, see geom
+	PropertyNames     map[string]string            `json:"property_names"`      // column -> output property key, for a clean schema over import quirks
+	HoursColumn       string                       `json:"hours_column"`        // opening hours (see hours.go) behind is_open_now, optional
+	Timezone          string                       `json:"timezone"`            // IANA zone the hours are in, required with hours_column
+
+	version  string         // hash of the validated descriptor, keys cached tiles
+	location *time.Location // loaded Timezone, nil without hours_column
+}
+
+// defaultDataset is the recycling drop-off table imported from
+// data/recycling-locations.geojson.
+// The import has no dedicated category field, so the service zone is used for grouping.
+var defaultDataset = &dataset{
+	Key:            "recycling",
+	DisplayName:    "Austin Recycling Drop-Off Locations",
+	Table:          "austinrecycling",
+	GeomColumn:     "wkb_geometry",
+	IDColumn:       "ogc_fid",
+	SRID:           4326,
+	CategoryColumn: "zone",
+	Filters:        []string{"zone", "address_zip", "status", "phone"},
+	Aliases:        []string{"dropoffs"}, // the layer's name in the original locations.go service
+	GeometryType:   "point",
+}
+
+// datasetRegistry is an immutable set of datasets. It is replaced as a whole
+// on reload, so a request always sees a consistent registry.
+type datasetRegistry struct {
+	byKey   map[string]*dataset
+	aliases map[string]*dataset // deprecated keys, see dataset.Aliases
+	def     *dataset            // used when a request names no dataset
+}
+
+// resolve finds the dataset named key, following aliases. alias reports
+// whether key was a deprecated alias rather than the current key.
+func (reg *datasetRegistry) resolve(key string) (ds *dataset, alias bool) {
+	if ds, ok := reg.byKey[key]; ok {
+		return ds, false
+	}
+	ds, ok := reg.aliases[key]
+	return ds, ok
+}
+
+// registry holds the current *datasetRegistry, swapped atomically by /admin/reload.
+var registry atomic.Pointer[datasetRegistry]
+
+func init() {
+	reg := &datasetRegistry{
+		byKey:   map[string]*dataset{defaultDataset.Key: defaultDataset},
+		aliases: map[string]*dataset{},
+		def:     defaultDataset,
+	}
+	for _, alias := range defaultDataset.Aliases {
+		reg.aliases[alias] = defaultDataset
+	}
+	registry.Store(reg)
+}
+
+// currentDatasets returns the registry in effect.
+func currentDatasets() *datasetRegistry {
+	return registry.Load()
+}
+
+// loadDatasets reads the descriptors in path into a new registry without
+// installing it. The first descriptor in the file becomes the default dataset.
+func loadDatasets(path string) (*datasetRegistry, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading datasets file: %w", err)
+	}
+	var list []*dataset
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, fmt.Errorf("parsing datasets file %s: %w", path, err)
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("datasets file %s defines no datasets", path)
+	}
+
+	reg := &datasetRegistry{byKey: make(map[string]*dataset, len(list)), aliases: map[string]*dataset{}, def: list[0]}
+	for i, ds := range list {
+		if ds.Key == "" || ds.Table == "" {
+			return nil, fmt.Errorf("datasets file %s: entry %d needs both key and table", path, i)
+		}
+		if _, dup := reg.byKey[ds.Key]; dup {
+			return nil, fmt.Errorf("datasets file %s: duplicate key %q", path, ds.Key)
+		}
+		if ds.GeomColumn == "" {
+			ds.GeomColumn = "wkb_geometry"
+		}
+		if ds.IDColumn == "" {
+			ds.IDColumn = "ogc_fid"
+		}
+		if ds.MaxPropertyLength < 0 {
+			return nil, fmt.Errorf("datasets file %s: max_property_length of %q must not be negative", path, ds.Key)
+		}
+		if ds.GeometryType == "" {
+			ds.GeometryType = "point"
+		}
+		if !geometryTypes[ds.GeometryType] {
+			return nil, fmt.Errorf("datasets file %s: geometry_type of %q: unsupported %q (use point, line or polygon)", path, ds.Key, ds.GeometryType)
+		}
+		for col, typ := range ds.PropertyTypes {
+			if _, ok := propertyTypeCasts[typ]; !ok {
+				return nil, fmt.Errorf("datasets file %s: property_types of %q: unsupported type %q for %q (use int, number, boolean or string)",
+					path, ds.Key, typ, col)
+			}
+		}
+		if ds.HoursColumn != "" {
+			if ds.Timezone == "" {
+				return nil, fmt.Errorf("datasets file %s: hours_column of %q needs a timezone (e.g. America/Chicago)", path, ds.Key)
+			}
+			loc, err := time.LoadLocation(ds.Timezone)
+			if err != nil {
+				return nil, fmt.Errorf("datasets file %s: timezone of %q: %w", path, ds.Key, err)
+			}
+			ds.location = loc
+		}
+		for i := range ds.DefaultOrder {
+			t := &ds.DefaultOrder[i]
+			t.Direction = strings.ToLower(t.Direction)
+			if t.Direction == "" {
+				t.Direction = "asc"
+			}
+			if t.Column == "" || (t.Direction != "asc" && t.Direction != "desc") {
+				return nil, fmt.Errorf("datasets file %s: default_order of %q: entry %d needs a column and a direction of asc or desc", path, ds.Key, i+1)
+			}
+		}
+		renamed := make(map[string]string, len(ds.PropertyNames))
+		for col, name := range ds.PropertyNames {
+			if name == "" {
+				return nil, fmt.Errorf("datasets file %s: property_names of %q: empty name for column %q", path, ds.Key, col)
+			}
+			if other, ok := renamed[name]; ok {
+				return nil, fmt.Errorf("datasets file %s: property_names of %q: columns %q and %q both renamed to %q",
+					path, ds.Key, min(col, other), max(col, other), name)
+			}
+			renamed[name] = col
+		}
+		for field, columns := range ds.LocalizedFields {
+			if _, ok := columns[ds.DefaultLanguage]; !ok {
+				return nil, fmt.Errorf("datasets file %s: localized field %q of %q needs a column for the default_language %q",
+					path, field, ds.Key, ds.DefaultLanguage)
+			}
+		}
+		if b := ds.Boundaries; b != nil && (b.Table == "" || b.IDColumn == "" || b.GeomColumn == "") {
+			return nil, fmt.Errorf("datasets file %s: boundaries of %q need table, id_column and geometry_column", path, ds.Key)
+		}
+		if b := ds.PostalCodes; b != nil && (b.Table == "" || b.IDColumn == "" || b.GeomColumn == "") {
+			return nil, fmt.Errorf("datasets file %s: postal_codes of %q need table, id_column and geometry_column", path, ds.Key)
+		}
+		reg.byKey[ds.Key] = ds
+	}
+	// Aliases are checked once every key is known, so an alias can never shadow a dataset
+	for _, ds := range list {
+		for _, alias := range ds.Aliases {
+			if _, taken := reg.byKey[alias]; taken {
+				return nil, fmt.Errorf("datasets file %s: alias %q of %q is already a dataset key", path, alias, ds.Key)
+			}
+			if other, dup := reg.aliases[alias]; dup {
+				return nil, fmt.Errorf("datasets file %s: alias %q is used by both %q and %q", path, alias, other.Key, ds.Key)
+			}
+			reg.aliases[alias] = ds
+		}
+	}
+	return reg, nil
+}
+
+// lookupDataset resolves the `dataset` query parameter, defaulting to the registry default.
+// Aliases resolve to their dataset; withDatasetAccess flags them as deprecated.
+func lookupDataset(key string) (*dataset, *apiError) {
+	reg := currentDatasets()
+	if key == "" {
+		return reg.def, nil
+	}
+	ds, _ := reg.resolve(key)
+	if ds == nil {
+		return nil, &apiError{Status: http.StatusNotFound, Code: codeUnknownDataset, Message: fmt.Sprintf("unknown dataset %q", key)}
+	}
+	return ds, nil
+}
+
+// filterable reports whether clients may filter on column.
+func (d *dataset) filterable(column string) bool {
+	for _, c := range d.Filters {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// numeric reports whether column holds numbers: a property_types entry of
+// int or number, or the popularity column.
+func (d *dataset) numeric(column string) bool {
+	if column == d.PopularityColumn && column != "" {
+		return true
+	}
+	switch d.PropertyTypes[column] {
+	case "int", "number":
+		return true
+	}
+	return false
+}
+
+// public reports whether ds is readable without an API key.
+func (d *dataset) public() bool {
+	return d.Public == nil || *d.Public
+}
+
+// quotedTable returns the dataset table as a quoted identifier.
+func (d *dataset) quotedTable() string {
+	return quoteTable(d.Table)
+}
+
+// quoteTable quotes an optionally schema-qualified table name.
+func quoteTable(table string) string {
+	parts := strings.SplitN(table, ".", 2)
+	for i := range parts {
+		parts[i] = pq.QuoteIdentifier(parts[i])
+	}
+	return strings.Join(parts, ".")
+}
+
+// geomCol returns the quoted geometry column in the dataset's native SRID.
+// Use it for index-friendly predicates (&&, ST_Intersects against a transformed envelope).
+func (d *dataset) geomCol() string {
+	return pq.QuoteIdentifier(d.GeomColumn)
+}
+
+// geom returns the geometry column expressed in WGS84 (EPSG:4326).
+// With RepairGeometry the column is passed through ST_MakeValid first, so
+// invalid imports cannot make distance or output functions error; that costs
+// a repair per row, and index predicates on geomCol still see the raw shape.
+func (d *dataset) geom() string {
+	geom := d.geomCol()
+	if d.RepairGeometry {
+		geom = fmt.Sprintf("ST_MakeValid(%s)", geom)
+	}
+	if d.SRID == 4326 {
+		return geom
+	}
+	return fmt.Sprintf("ST_Transform(%s, 4326)", geom)
+}
+
+// nativeEnvelope builds a WGS84 envelope from four SQL expressions (usually
+// placeholders) and transforms it to the dataset SRID, so predicates on geomCol
+// can use the spatial index.
+func (d *dataset) nativeEnvelope(minLng, minLat, maxLng, maxLat string) string {
+	env := fmt.Sprintf("ST_MakeEnvelope(%s, %s, %s, %s, 4326)", minLng, minLat, maxLng, maxLat)
+	if d.SRID == 4326 {
+		return env
+	}
+	return fmt.Sprintf("ST_Transform(%s, %d)", env, d.SRID)
+}
+
+// idCol returns the quoted feature id column.
+func (d *dataset) idCol() string {
+	return pq.QuoteIdentifier(d.IDColumn)
+}
+
+// orderTerm is one (column, direction) pair of a dataset's default_order.
+// The column orderDistance stands for the distance from the search center.
+type orderTerm struct {
+	Column    string `json:"column"`
+	Direction string `json:"direction"` // asc (default) or desc
+}
+
+// orderDistance is the pseudo column of default_order that sorts by distance.
+const orderDistance = "distance"
+
+// defaultOrderBy returns the ORDER BY list of DefaultOrder, with distance
+// standing for the distance expression. Distance is appended as the final
+// tie-breaker when the list does not place it, so the order (and the rank
+// property) stays deterministic. DESC puts NULLs last, like boost does.
+func (d *dataset) defaultOrderBy(distance string) string {
+	terms := make([]string, 0, len(d.DefaultOrder)+1)
+	hasDistance := false
+	for _, t := range d.DefaultOrder {
+		expr := pq.QuoteIdentifier(t.Column)
+		if t.Column == orderDistance {
+			expr, hasDistance = distance, true
+		}
+		if t.Direction == "desc" {
+			expr += " DESC NULLS LAST"
+		}
+		terms = append(terms, expr)
+	}
+	if !hasDistance {
+		terms = append(terms, distance)
+	}
+	return strings.Join(terms, ", ")
+}
+
+// geometryTypes are the values allowed in geometry_type. The hint describes
+// what a dataset mostly holds; line and polygon datasets get a nearest_point
+// property, since their "location" is not a single coordinate.
+var geometryTypes = map[string]bool{
+	"point":   true,
+	"line":    true,
+	"polygon": true,
+}
+
+// extended reports whether d holds lines or polygons rather than points.
+func (d *dataset) extended() bool {
+	return d.GeometryType == "line" || d.GeometryType == "polygon"
+}
+
+// propertyTypeCasts are the values allowed in property_types, mapped to the
+// SQL type the column is cast to before serialization. Tables imported from
+// CSV often keep numbers and flags as text; the cast fixes their JSON type.
+var propertyTypeCasts = map[string]string{
+	"int":     "bigint",
+	"number":  "double precision",
+	"boolean": "boolean",
+	"string":  "text",
+}
+
+// propertiesExpr returns the jsonb properties of rowAlias, minus the id and
+// geometry columns and any query helper columns listed in hidden.
+// Columns listed in PropertyTypes are re-added with their configured type,
+// then keys are renamed per PropertyNames and long strings truncated.
+func (d *dataset) propertiesExpr(rowAlias string, hidden ...string) string {
+	expr := fmt.Sprintf("to_jsonb(%s) - %s - %s", rowAlias, pq.QuoteLiteral(d.IDColumn), pq.QuoteLiteral(d.GeomColumn))
+	for _, col := range hidden {
+		expr += " - " + pq.QuoteLiteral(col)
+	}
+	if len(d.PropertyTypes) > 0 {
+		// Sorted so the generated SQL (and its plan cache entry) is stable
+		cols := make([]string, 0, len(d.PropertyTypes))
+		for col := range d.PropertyTypes {
+			cols = append(cols, col)
+		}
+		sort.Strings(cols)
+		casts := make([]string, 0, len(cols))
+		for _, col := range cols {
+			// Blank strings become null rather than failing the cast
+			casts = append(casts, fmt.Sprintf("%s, NULLIF(btrim(%s.%s::text), '')::%s",
+				pq.QuoteLiteral(col), rowAlias, pq.QuoteIdentifier(col), propertyTypeCasts[d.PropertyTypes[col]]))
+		}
+		expr = fmt.Sprintf("(%s) || jsonb_build_object(%s)", expr, strings.Join(casts, ", "))
+	}
+	if len(d.PropertyNames) == 0 && d.MaxPropertyLength == 0 {
+		return expr
+	}
+
+	// Renaming and truncation share one pass over the object's entries
+	key, value := "key", "value"
+	if len(d.PropertyNames) > 0 {
+		cols := make([]string, 0, len(d.PropertyNames))
+		for col := range d.PropertyNames {
+			cols = append(cols, col)
+		}
+		sort.Strings(cols)
+		key = "CASE key"
+		for _, col := range cols {
+			key += fmt.Sprintf(" WHEN %s THEN %s", pq.QuoteLiteral(col), pq.QuoteLiteral(d.PropertyNames[col]))
+		}
+		key += " ELSE key END"
+	}
+	if d.MaxPropertyLength > 0 {
+		// Long strings (descriptions, raw source blobs) are cut to the limit plus an ellipsis,
+		// so a few fat rows cannot dominate the payload. Other JSON types pass through.
+		value = fmt.Sprintf(`CASE
+				WHEN jsonb_typeof(value) = 'string' AND length(value #>> '{}') > %[1]d
+				THEN to_jsonb(left(value #>> '{}', %[1]d) || '…')
+				ELSE value END`, d.MaxPropertyLength)
+	}
+	return fmt.Sprintf("COALESCE((SELECT jsonb_object_agg(%s, %s) FROM jsonb_each(%s)), '{}'::jsonb)", key, value, expr)
+}
+
+// propertyName returns the output key of column, see PropertyNames.
+func (d *dataset) propertyName(column string) string {
+	if name, ok := d.PropertyNames[column]; ok {
+		return name
+	}
+	return column
+}
+
+// hasLanguage reports whether any localized field of d has a column for lang.
+func (d *dataset) hasLanguage(lang string) bool {
+	for _, byLang := range d.LocalizedFields {
+		if _, ok := byLang[lang]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// localizedExpr returns the jsonb adding each localized field of rowAlias
+// under its canonical name (e.g. name from name_es), in lang with a fallback
+// to the default language when that column is missing or empty. It returns
+// an empty string for datasets without localized fields.
+func (d *dataset) localizedExpr(rowAlias, lang string) string {
+	if len(d.LocalizedFields) == 0 {
+		return ""
+	}
+	if lang == "" {
+		lang = d.DefaultLanguage
+	}
+
+	// Sorted so the generated SQL (and its plan cache entry) is stable
+	fields := make([]string, 0, len(d.LocalizedFields))
+	for field := range d.LocalizedFields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	pairs := make([]string, 0, len(fields))
+	for _, field := range fields {
+		byLang := d.LocalizedFields[field]
+		value := fmt.Sprintf("%s.%s", rowAlias, pq.QuoteIdentifier(byLang[d.DefaultLanguage]))
+		if col, ok := byLang[lang]; ok && lang != d.DefaultLanguage {
+			value = fmt.Sprintf("COALESCE(NULLIF(btrim(%s.%s::text), ''), %s::text)", rowAlias, pq.QuoteIdentifier(col), value)
+		}
+		pairs = append(pairs, fmt.Sprintf("%s, %s", pq.QuoteLiteral(field), value))
+	}
+	return fmt.Sprintf("jsonb_build_object(%s)", strings.Join(pairs, ", "))
+}
+
+// validateDatasets checks that every dataset of reg points at an existing
+// table and columns, so a typo or missing import fails at boot (or reload)
+// instead of on the first search. Missing SRIDs are detected and filled in.
+func validateDatasets(reg *datasetRegistry) error {
+	for key, ds := range reg.byKey {
+		columns := append([]string{ds.GeomColumn, ds.IDColumn}, ds.Filters...)
+		if ds.CategoryColumn != "" {
+			columns = append(columns, ds.CategoryColumn)
+		}
+		if ds.UpdatedColumn != "" {
+			columns = append(columns, ds.UpdatedColumn)
+		}
+		if ds.FeaturedColumn != "" {
+			columns = append(columns, ds.FeaturedColumn)
+		}
+		if ds.PopularityColumn != "" {
+			columns = append(columns, ds.PopularityColumn)
+		}
+		for col := range ds.PropertyTypes {
+			columns = append(columns, col)
+		}
+		for col := range ds.PropertyNames {
+			columns = append(columns, col)
+		}
+		if ds.HoursColumn != "" {
+			columns = append(columns, ds.HoursColumn)
+		}
+		for _, t := range ds.DefaultOrder {
+			if t.Column != orderDistance {
+				columns = append(columns, t.Column)
+			}
+		}
+		for _, byLang := range ds.LocalizedFields {
+			for _, col := range byLang {
+				columns = append(columns, col)
+			}
+		}
+		if err := checkTable(key, ds.Table, columns); err != nil {
+			return err
+		}
+		if b := ds.Boundaries; b != nil {
+			if err := checkTable(key, b.Table, []string{b.IDColumn, b.GeomColumn}); err != nil {
+				return fmt.Errorf("boundaries: %w", err)
+			}
+			if err := detectIDType(key, b); err != nil {
+				return fmt.Errorf("boundaries: %w", err)
+			}
+		}
+		if b := ds.PostalCodes; b != nil {
+			if err := checkTable(key, b.Table, []string{b.IDColumn, b.GeomColumn}); err != nil {
+				return fmt.Errorf("postal_codes: %w", err)
+			}
+			if err := detectIDType(key, b); err != nil {
+				return fmt.Errorf("postal_codes: %w", err)
+			}
+		}
+		if ds.SRID == 0 {
+			if err := detectSRID(ds); err != nil {
+				return err
+			}
+		}
+		ds.version = descriptorVersion(ds)
+		log.Printf("Dataset %q validated (table %s, SRID %d)", key, ds.Table, ds.SRID)
+	}
+	return nil
+}
+
+// descriptorVersion hashes the descriptor, so anything derived from it (like
+// cached tiles) is invalidated when a reload changes the dataset.
+func descriptorVersion(ds *dataset) string {
+	raw, _ := json.Marshal(ds)
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:8])
+}
+
+// checkTable verifies that table exists and has every listed column.
+func checkTable(key, table string, columns []string) error {
+	var exists bool
+	err := db.QueryRow(
+		`SELECT EXISTS (
+			SELECT 1 FROM information_schema.tables
+			WHERE table_schema || '.' || table_name = $1
+				OR (table_name = $1 AND table_schema = ANY(current_schemas(false)))
+		)`, table).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("dataset %q: checking table %q: %w", key, table, err)
+	}
+	if !exists {
+		return fmt.Errorf("dataset %q: table %q does not exist (was the GeoJSON imported?)", key, table)
+	}
+
+	var missing []string
+	err = db.QueryRow(
+		`SELECT COALESCE(array_agg(c), '{}')
+		FROM unnest($2::text[]) AS c
+		WHERE NOT EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE column_name = c
+				AND (table_schema || '.' || table_name = $1
+					OR (table_name = $1 AND table_schema = ANY(current_schemas(false))))
+		)`, table, pq.Array(columns)).Scan(pq.Array(&missing))
+	if err != nil {
+		return fmt.Errorf("dataset %q: checking columns of %q: %w", key, table, err)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("dataset %q: table %q has no column(s) %s", key, table, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// detectSRID fills in ds.SRID from the geometry_columns metadata via Find_SRID.
+// An SRID of 0 means the column was imported without a projection; WGS84 is
+// assumed in that case, which is only correct if the source data was lng/lat.
+func detectSRID(ds *dataset) error {
+	schema, table := "", ds.Table
+	if i := strings.Index(ds.Table, "."); i >= 0 {
+		schema, table = ds.Table[:i], ds.Table[i+1:]
+	}
+
+	var srid int
+	err := db.QueryRow(
+		`SELECT Find_SRID(COALESCE(NULLIF($1, ''), current_schema()), $2, $3)`,
+		schema, table, ds.GeomColumn).Scan(&srid)
+	if err != nil {
+		return fmt.Errorf("dataset %q: detecting SRID of %s.%s: %w", ds.Key, ds.Table, ds.GeomColumn, err)
+	}
+
+	if srid == 0 {
+		log.Printf("WARNING: dataset %q: geometry column %s has unknown SRID 0, assuming 4326. Set \"srid\" explicitly if that is wrong.",
+			ds.Key, ds.GeomColumn)
+		srid = 4326
+	}
+	ds.SRID = srid
+	return nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Global database connection pools.
+// db is the primary and is reserved for writes and health checks; readDB serves
+// search traffic and points at the read replica when one is configured.
+var (
+	db     *sql.DB
+	readDB *sql.DB
+)
+
+// cloudSQLSocketRoot is where App Engine and Cloud Run mount Cloud SQL Unix sockets.
+const cloudSQLSocketRoot = "/cloudsql"
+
+// maxOpenConns caps each connection pool; it also sizes the default query slots in loadshed.go.
+const maxOpenConns = 7
+
+// defaultAcquireTimeout bounds how long a query waits for a pooled connection
+// (CONN_ACQUIRE_TIMEOUT overrides it).
+const defaultAcquireTimeout = 2 * time.Second
+
+var acquireTimeout = defaultAcquireTimeout
+
+// Connection establishment. connect_timeout bounds a single dial (the Cloud
+// SQL socket can be slow on a cold start) and failed startup pings are
+// retried with exponential backoff instead of failing the instance at once.
+const (
+	defaultConnectTimeout  = 10 * time.Second
+	defaultConnectAttempts = 5
+	initialConnectBackoff  = time.Second
+	maxConnectBackoff      = 16 * time.Second
+)
+
+// statementTimeout is the server-side statement_timeout of every pooled
+// connection (DB_STATEMENT_TIMEOUT, 0 for none). A query running longer is
+// killed by PostgreSQL with SQLSTATE 57014, which queryError turns into a
+// 504 query_timeout. Bulk jobs (export, import, geometry repair, summaries)
+// lift it with noStatementTimeout.
+var statementTimeout time.Duration
+
+// noStatementTimeout lifts statementTimeout for the rest of a transaction.
+const noStatementTimeout = "SET LOCAL statement_timeout = 0"
+
+var (
+	connectTimeout  = defaultConnectTimeout  // DB_CONNECT_TIMEOUT
+	connectAttempts = defaultConnectAttempts // DB_CONNECT_ATTEMPTS
+)
+
+// errPoolExhausted is returned by acquireConn when no connection became free in time.
+// Handlers map it to a 503 pool_exhausted, distinct from a slow query.
+var errPoolExhausted = errors.New("connection pool exhausted")
+
+// acquireConn takes a dedicated connection from pool, waiting at most acquireTimeout.
+// The deadline only covers the acquisition; queries on the returned connection
+// still run under ctx. Callers must Close the connection to return it to the pool.
+func acquireConn(ctx context.Context, pool *sql.DB) (*sql.Conn, error) {
+	acquireCtx, cancel := context.WithTimeout(ctx, acquireTimeout)
+	defer cancel()
+
+	conn, err := pool.Conn(acquireCtx)
+	if err != nil {
+		// Only our own deadline means exhaustion; a canceled request is reported as is
+		if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			stats := pool.Stats()
+			return nil, fmt.Errorf("%w: no connection within %s (in use %d of %d)",
+				errPoolExhausted, acquireTimeout, stats.InUse, stats.MaxOpenConnections)
+		}
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	return conn, nil
+}
+
+// dbConfig holds the connection settings for a single Cloud SQL instance.
+type dbConfig struct {
+	InstanceConnectionName string // Unix socket mode when set (App Engine / Cloud Run)
+	Host                   string // TCP mode otherwise (local Cloud SQL Proxy)
+	Port                   string
+	User                   string
+	Password               string
+	Name                   string
+}
+
+// connectionString renders the lib/pq key/value DSN for the config.
+// NOTE: Never log the result directly, always go through redactDSN.
+func (c dbConfig) connectionString() string {
+	// Check if running on App Engine (using unix socket)
+	// connect_timeout is in whole seconds, lib/pq ignores anything finer
+	timeout := max(int(connectTimeout/time.Second), 1)
+	// Unknown keys are sent as run-time parameters, so this sets the session's statement_timeout (ms)
+	var params string
+	if statementTimeout > 0 {
+		params = fmt.Sprintf(" statement_timeout=%d", statementTimeout.Milliseconds())
+	}
+	if c.InstanceConnectionName != "" {
+		return fmt.Sprintf("user=%s password=%s database=%s host=%s connect_timeout=%d%s",
+			c.User, c.Password, c.Name, c.socketDir(), timeout, params)
+	}
+	// FIX: Explicitly disable SSL for local connection via the proxy
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s database=%s sslmode=disable connect_timeout=%d%s",
+		c.Host, c.Port, c.User, c.Password, c.Name, timeout, params)
+}
+
+// socketDir is the directory holding the instance's Unix socket in socket mode.
+func (c dbConfig) socketDir() string {
+	return cloudSQLSocketRoot + "/" + c.InstanceConnectionName
+}
+
+// checkSocketDir verifies the Cloud SQL socket is mounted before connecting.
+// Without this, a missing mount surfaces as an opaque "no such file" dial error.
+func checkSocketDir(c dbConfig) error {
+	if c.InstanceConnectionName == "" {
+		return nil
+	}
+	info, err := os.Stat(c.socketDir())
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("Cloud SQL socket directory %s is not mounted: add %q to beta_settings.cloud_sql_instances in app.yaml "+
+			"(App Engine) or to --add-cloudsql-instances (Cloud Run)", c.socketDir(), c.InstanceConnectionName)
+	}
+	return nil
+}
+
+// primaryDBConfig reads the primary instance settings from the environment.
+func primaryDBConfig() dbConfig {
+	// Credentials retrieved from App Engine environment variables (or local shell)
+	cfg := dbConfig{
+		InstanceConnectionName: os.Getenv("INSTANCE_CONNECTION_NAME"),
+		Host:                   "127.0.0.1",
+		Port:                   "5432",
+		User:                   os.Getenv("DB_USER"),
+		Password:               os.Getenv("DB_PASSWORD"),
+		Name:                   os.Getenv("DB_NAME"),
+	}
+
+	// Fallback/Local values
+	if cfg.User == "" {
+		cfg.User = "postgres"
+	}
+	if cfg.Name == "" {
+		cfg.Name = "recycling_db"
+	}
+	return cfg
+}
+
+// replicaDBConfig reads the optional read replica settings (DB_REPLICA_*).
+// Credentials default to the primary's; ok is false when no replica is configured.
+func replicaDBConfig(primary dbConfig) (cfg dbConfig, ok bool) {
+	cfg = primary
+	cfg.InstanceConnectionName = os.Getenv("DB_REPLICA_INSTANCE_CONNECTION_NAME")
+	cfg.Host = os.Getenv("DB_REPLICA_HOST")
+	if cfg.InstanceConnectionName == "" && cfg.Host == "" {
+		return dbConfig{}, false
+	}
+
+	if v := os.Getenv("DB_REPLICA_PORT"); v != "" {
+		cfg.Port = v
+	}
+	if v := os.Getenv("DB_REPLICA_USER"); v != "" {
+		cfg.User = v
+	}
+	if v := os.Getenv("DB_REPLICA_PASSWORD"); v != "" {
+		cfg.Password = v
+	}
+	if v := os.Getenv("DB_REPLICA_NAME"); v != "" {
+		cfg.Name = v
+	}
+	return cfg, true
+}
+
+// validateConfig checks that the database settings are coherent before any
+// connection is attempted, turning cryptic driver errors into clear messages.
+func validateConfig() error {
+	cfg := primaryDBConfig()
+	if cfg.InstanceConnectionName != "" && cfg.Password == "" {
+		return fmt.Errorf("INSTANCE_CONNECTION_NAME is set (%s) but DB_PASSWORD is empty", cfg.InstanceConnectionName)
+	}
+	if replica, ok := replicaDBConfig(cfg); ok && replica.Password == "" && replica.InstanceConnectionName != "" {
+		return fmt.Errorf("DB_REPLICA_INSTANCE_CONNECTION_NAME is set (%s) but no password is configured", replica.InstanceConnectionName)
+	}
+	return nil
+}
+
+// initDB establishes the connection to the Cloud SQL instance, plus the read
+// replica if DB_REPLICA_* is set. Without a replica, readDB is the primary pool.
+func initDB() error {
+	primary := primaryDBConfig()
+	if primary.InstanceConnectionName == "" && primary.Password == "" {
+		// Local development via Cloud SQL Proxy (tcp connection)
+		log.Println("WARNING: DB_PASSWORD environment variable not set. Assuming unsecure local connection.")
+	}
+
+	var err error
+	if db, err = openPool(primary); err != nil {
+		return err
+	}
+	log.Printf("Successfully connected to database: %s (%s)", primary.Name, redactDSN(primary.connectionString()))
+
+	replica, ok := replicaDBConfig(primary)
+	if !ok {
+		readDB = db
+		return nil
+	}
+	if readDB, err = openPool(replica); err != nil {
+		return fmt.Errorf("read replica: %w", err)
+	}
+	log.Printf("Successfully connected to read replica: %s (%s)", replica.Name, redactDSN(replica.connectionString()))
+	return nil
+}
+
+// openPool opens and verifies a connection pool for cfg.
+func openPool(cfg dbConfig) (*sql.DB, error) {
+	if err := checkSocketDir(cfg); err != nil {
+		return nil, err
+	}
+	connectionString := cfg.connectionString()
+
+	connector, err := newJitterConnector(connectionString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection settings (%s): %w", redactDSN(connectionString), err)
+	}
+	pool := sql.OpenDB(connector)
+
+	// Configure pool settings (adopted from locations.go logic)
+	pool.SetMaxIdleConns(5)
+	pool.SetMaxOpenConns(maxOpenConns)
+	// FIX: the lifetime used to be SetConnMaxLifetime(1800), i.e. 1800ns, which
+	// recycled every connection after each use. Each connection now expires
+	// after its own jittered lifetime (see dbconn.go); this is the upper bound.
+	pool.SetConnMaxLifetime(maxJitteredLifetime())
+
+	// Verify connection
+	if err = pingWithRetry(pool, cfg.Name); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("db.Ping failed (%s): %w", redactDSN(connectionString), err)
+	}
+	if err = checkPostGIS(pool, cfg.Name); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// pingWithRetry pings pool up to connectAttempts times, doubling the wait
+// between attempts. Each attempt is bounded by connect_timeout in the DSN.
+// Attempts are logged as "db connect" so they are not mistaken for slow
+// queries (those surface as query errors with a request id).
+func pingWithRetry(pool *sql.DB, name string) error {
+	backoff := initialConnectBackoff
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := pool.Ping()
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("db connect: %s reachable after %d attempts", name, attempt)
+			}
+			return nil
+		}
+		if attempt >= connectAttempts {
+			return fmt.Errorf("giving up after %d connect attempts: %w", attempt, err)
+		}
+		log.Printf("WARNING: db connect: attempt %d/%d to %s failed after %s: %v; retrying in %s",
+			attempt, connectAttempts, name, time.Since(start).Round(time.Millisecond), err, backoff)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxConnectBackoff)
+	}
+}
+
+// checkPostGIS fails fast when the database lacks the PostGIS extension.
+// Without it every search dies on "function st_dwithin does not exist",
+// which is far harder to trace back to a misconfigured instance.
+func checkPostGIS(pool *sql.DB, name string) error {
+	var version string
+	if err := pool.QueryRow("SELECT postgis_version()").Scan(&version); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "42883" { // undefined_function
+			return fmt.Errorf("PostGIS is not installed in database %q, run `CREATE EXTENSION postgis;` as a superuser", name)
+		}
+		return fmt.Errorf("checking PostGIS version: %w", err)
+	}
+	log.Printf("PostGIS %s available in database %s", version, name)
+	return nil
+}
+
+// dsnPasswordPattern matches the password=... pair of a key/value DSN,
+// including single-quoted values that may contain spaces.
+var dsnPasswordPattern = regexp.MustCompile(`password=('(?:[^'\\]|\\.)*'|\S*)`)
+
+// redactDSN masks the password in a connection string so it is safe to log.
+func redactDSN(dsn string) string {
+	return dsnPasswordPattern.ReplaceAllString(dsn, "password=REDACTED")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Connection recycling. Each connection gets its own lifetime, spread by
+// ±DB_CONN_LIFETIME_JITTER_PERCENT around DB_CONN_MAX_LIFETIME, so connections
+// opened together (at startup, after a failover) do not all expire and
+// reconnect in the same instant.
+const (
+	defaultConnMaxLifetime       = 30 * time.Minute
+	defaultConnLifetimeJitterPct = 10
+)
+
+var (
+	connMaxLifetime       = defaultConnMaxLifetime
+	connLifetimeJitterPct = defaultConnLifetimeJitterPct
+)
+
+// maxJitteredLifetime is the longest lifetime jitteredLifetime can return. It
+// is also set as the pool's ConnMaxLifetime, so idle connections that were
+// never handed back after expiring are still closed by database/sql.
+func maxJitteredLifetime() time.Duration {
+	return connMaxLifetime + connMaxLifetime*time.Duration(connLifetimeJitterPct)/100
+}
+
+// jitteredLifetime picks a lifetime uniformly in connMaxLifetime ± the jitter.
+func jitteredLifetime() time.Duration {
+	spread := float64(connMaxLifetime) * float64(connLifetimeJitterPct) / 100
+	return connMaxLifetime + time.Duration((rand.Float64()*2-1)*spread)
+}
+
+// jitterConnector opens pq connections that expire after their own jittered
+// lifetime. database/sql has no per-connection lifetime, but it asks a
+// driver.Validator before reusing a connection and drops invalid ones.
+type jitterConnector struct {
+	*pq.Connector
+}
+
+// newJitterConnector wraps a pq connector for dsn.
+func newJitterConnector(dsn string) (*jitterConnector, error) {
+	c, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &jitterConnector{c}, nil
+}
+
+func (c *jitterConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pc, ok := conn.(pqConn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected driver connection type %T", conn)
+	}
+	return &expiringConn{pqConn: pc, expires: time.Now().Add(jitteredLifetime())}, nil
+}
+
+// pqConn is the set of driver interfaces the lib/pq connection implements.
+// Wrapping hides every method not listed here, so the wrapper must forward
+// all of them for database/sql to keep using contexts, sessions and pings.
+type pqConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.QueryerContext
+	driver.ExecerContext
+	driver.Pinger
+	driver.SessionResetter
+	driver.Validator
+}
+
+// expiringConn is a pq connection that reports itself invalid once expired.
+type expiringConn struct {
+	pqConn
+	expires time.Time
+}
+
+// IsValid implements driver.Validator.
+func (c *expiringConn) IsValid() bool {
+	return time.Now().Before(c.expires) && c.pqConn.IsValid()
+}
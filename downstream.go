@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Downstream integrations. No geocoding or routing provider is wired in yet;
+// these interfaces fix the contract for when one lands: every call takes the
+// request context, so a client disconnect (or the request deadline) cancels
+// the upstream HTTP call instead of leaving a goroutine waiting on it.
+
+// Geocoder resolves a free-form address to coordinates.
+type Geocoder interface {
+	Geocode(ctx context.Context, address string) (lat, lng float64, err error)
+}
+
+// Router computes the travel distance and time between two points.
+type Router interface {
+	Route(ctx context.Context, fromLat, fromLng, toLat, toLng float64) (meters, seconds float64, err error)
+}
+
+// fetchJSON GETs url with ctx and decodes the JSON response into out. Geocoder
+// and Router implementations make their HTTP calls through it; the client's
+// own timeout is only a backstop, the request context normally ends first.
+func fetchJSON(ctx context.Context, client *http.Client, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("downstream request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downstream request returned status %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding downstream response: %w", err)
+	}
+	return nil
+}
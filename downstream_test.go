@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// blockingServer answers nothing until the client goes away, and reports on
+// aborted when it does.
+func blockingServer(t *testing.T) (srv *httptest.Server, started, aborted chan struct{}) {
+	started, aborted = make(chan struct{}), make(chan struct{})
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-r.Context().Done():
+			close(aborted)
+		case <-time.After(5 * time.Second):
+			t.Error("downstream request was not aborted")
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, started, aborted
+}
+
+func TestFetchJSONCanceledContext(t *testing.T) {
+	srv, started, _ := blockingServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var out any
+	err := fetchJSON(ctx, srv.Client(), srv.URL, &out)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("fetchJSON with a canceled context: got %v, want context.Canceled", err)
+	}
+	select {
+	case <-started:
+		t.Fatal("a canceled context still reached the downstream server")
+	default:
+	}
+}
+
+func TestFetchJSONCancelAbortsInFlightCall(t *testing.T) {
+	srv, started, aborted := blockingServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		var out any
+		done <- fetchJSON(ctx, srv.Client(), srv.URL, &out)
+	}()
+
+	// Cancel once the call is in flight, as a client disconnect would
+	<-started
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("fetchJSON after cancel: got %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("fetchJSON did not return after the context was canceled")
+	}
+	select {
+	case <-aborted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the downstream server did not see the request abort")
+	}
+}
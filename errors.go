@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+// Machine-readable error codes returned in the "code" field of error bodies.
+// The frontend keys off these to highlight the offending form field, so they
+// must stay stable once published.
+const (
+	codeMissingCoordinates    = "missing_coordinates"
+	codeInvalidLatitude       = "invalid_latitude"
+	codeInvalidLongitude      = "invalid_longitude"
+	codeOutOfRangeLatitude    = "out_of_range_latitude"
+	codeOutOfRangeLongitude   = "out_of_range_longitude"
+	codeInvalidRadius         = "invalid_radius"
+	codeOutOfRangeRadius      = "out_of_range_radius"
+	codeInvalidUnit           = "invalid_unit"
+	codeInvalidLimit          = "invalid_limit"
+	codeInvalidCategory       = "invalid_category"
+	codeInvalidFilter         = "invalid_filter"
+	codeInvalidFormat         = "invalid_format"
+	codeUnknownDataset        = "unknown_dataset"
+	codeBoundaryUnsupported   = "boundary_unsupported"
+	codeBoundaryNotFound      = "boundary_not_found"
+	codeZipUnsupported        = "zip_unsupported"
+	codeZipNotFound           = "zip_not_found"
+	codeInvalidTile           = "invalid_tile"
+	codeInvalidGeometry       = "invalid_geometry"
+	codeInvalidBuffer         = "invalid_buffer"
+	codeInvalidBBox           = "invalid_bbox"
+	codeInvalidCellSize       = "invalid_cell_size"
+	codeInvalidParameter      = "invalid_parameter"
+	codeConflictingParameters = "conflicting_parameters"
+	codeQueryTooExpensive     = "query_too_expensive"
+	codeUnauthorized          = "unauthorized"
+	codeForbidden             = "forbidden"
+	codeReloadFailed          = "reload_failed"
+	codeImportFailed          = "import_failed"
+	codeMethodNotAllowed      = "method_not_allowed"
+	codeBodyTooLarge          = "body_too_large"
+	codeNotFound              = "not_found"
+	codeNoResults             = "no_results"
+	codeOverloaded            = "overloaded"
+	codePoolExhausted         = "pool_exhausted"
+	codeQueryTimeout          = "query_timeout"
+	codeInternalError         = "internal_error"
+)
+
+// errorCatalogEntry documents one error code for /api/errors.
+type errorCatalogEntry struct {
+	Code        string `json:"code"`
+	Statuses    []int  `json:"statuses"` // HTTP statuses the code is returned with
+	Description string `json:"description"`
+}
+
+// errorCatalog lists every code above. Keep it in sync when adding a code:
+// integrators generate their error handling from /api/errors.
+var errorCatalog = []errorCatalogEntry{
+	{codeMissingCoordinates, []int{400}, "lat or lng is missing and no zip, boundary or bbox was given"},
+	{codeInvalidLatitude, []int{400}, "lat is not a number"},
+	{codeInvalidLongitude, []int{400}, "lng is not a number"},
+	{codeOutOfRangeLatitude, []int{400}, "lat is outside [-90, 90]; the message hints at swapped coordinates when likely"},
+	{codeOutOfRangeLongitude, []int{400}, "lng is outside [-180, 180]"},
+	{codeInvalidRadius, []int{400}, "radius or min_radius is not an integer, or max_distance is not a positive number"},
+	{codeOutOfRangeRadius, []int{400}, "radius or min_radius is not positive, or min_radius is not below radius (or max_distance)"},
+	{codeInvalidUnit, []int{400}, "unit is not km or mi"},
+	{codeInvalidLimit, []int{400}, "limit is not an integer within the allowed range"},
+	{codeInvalidCategory, []int{400}, "the category filter is empty or the dataset has no category column"},
+	{codeInvalidFilter, []int{400}, "a has or where filter names a non-filterable column or does not match the grammar"},
+	{codeInvalidFormat, []int{400}, "format or geometry_format is unsupported, or the combination is invalid"},
+	{codeUnknownDataset, []int{400, 404}, "the dataset key is not registered, or the datasets list is empty or repeats a key"},
+	{codeBoundaryUnsupported, []int{400}, "within_boundary was used on a dataset without boundaries"},
+	{codeBoundaryNotFound, []int{404}, "the within_boundary id does not exist"},
+	{codeZipUnsupported, []int{400}, "zip was used on a dataset without postal_codes"},
+	{codeZipNotFound, []int{404}, "the zip has no polygon in the dataset's postal_codes table"},
+	{codeInvalidTile, []int{400, 404}, "the z/x/y tile path is malformed or outside the tile grid"},
+	{codeInvalidGeometry, []int{400}, "a GeoJSON parameter (route, polygon) is malformed, of the wrong type or too large"},
+	{codeInvalidBuffer, []int{400}, "the corridor buffer is not an integer within the allowed range"},
+	{codeInvalidBBox, []int{400}, "bbox is not four numbers minLng,minLat,maxLng,maxLat within range"},
+	{codeInvalidCellSize, []int{400}, "cell_size is not a positive number of degrees or the grid would exceed GRID_MAX_CELLS cells"},
+	{codeInvalidParameter, []int{400}, "a parameter has an invalid value; the message names it"},
+	{codeConflictingParameters, []int{400}, "two mutually exclusive parameters were combined"},
+	{codeQueryTooExpensive, []int{400}, "the estimated search cost exceeds QUERY_COST_BUDGET; details lists the cost per factor"},
+	{codeUnauthorized, []int{401}, "the admin bearer token is missing or wrong"},
+	{codeForbidden, []int{403}, "the dataset is private and the X-API-Key header is missing or wrong"},
+	{codeReloadFailed, []int{409, 422}, "the datasets file is not configured or failed validation; the previous configuration stays active"},
+	{codeImportFailed, []int{422, 502}, "the import file could not be downloaded or did not fit the dataset table"},
+	{codeMethodNotAllowed, []int{405}, "the HTTP method is not supported; see the Allow header"},
+	{codeBodyTooLarge, []int{413}, "the request body is larger than MAX_BODY_BYTES"},
+	{codeNotFound, []int{404}, "no such API endpoint"},
+	{codeNoResults, []int{404}, "the search matched no features and empty_as=notfound was given"},
+	{codeOverloaded, []int{503}, "no query slot became free in time; retry after the Retry-After delay"},
+	{codePoolExhausted, []int{503}, "no database connection became free in time; retry after the Retry-After delay"},
+	{codeQueryTimeout, []int{504}, "the query ran longer than DB_STATEMENT_TIMEOUT and was stopped; narrow the request"},
+	{codeInternalError, []int{500}, "an unexpected server error"},
+}
+
+// apiErrorsHandler serves /api/errors: the catalog of error codes the API can return.
+func apiErrorsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", contentTypeJSON)
+	writeJSON(w, struct {
+		Status string              `json:"status"`
+		Errors []errorCatalogEntry `json:"errors"`
+	}{"ok", errorCatalog})
+}
+
+// apiError is an error that is safe to return to API clients.
+// Status is the HTTP status code, Code the machine-readable identifier.
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+	Details any // optional machine-readable context, serialized as "details"
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// badRequest builds a 400 apiError with a formatted message.
+func badRequest(code string, format string, args ...interface{}) *apiError {
+	return &apiError{Status: http.StatusBadRequest, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// queryError logs a failed database call with its PostgreSQL classification
+// (SQLSTATE code, severity, hint, ...) and returns a sanitized 500 for the
+// client: table names, SQL and constraint details stay in the server logs.
+// The request id lets support find the matching log line.
+func queryError(ctx context.Context, err error) *apiError {
+	attrs := []any{"error", err}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		attrs = append(attrs,
+			"pg_code", string(pqErr.Code),
+			"pg_class", pqErr.Code.Class().Name(),
+			"pg_severity", pqErr.Severity,
+			"pg_message", pqErr.Message,
+			"pg_detail", pqErr.Detail,
+			"pg_hint", pqErr.Hint,
+			"pg_table", pqErr.Table,
+			"pg_constraint", pqErr.Constraint,
+		)
+	}
+	requestLogger(ctx).Error("query failed", attrs...)
+
+	// 57014 is also what a canceled context produces (lib/pq sends a cancel
+	// request); only with the request still alive was it statement_timeout
+	if pqErr != nil && pqErr.Code == "57014" && ctx.Err() == nil {
+		return &apiError{
+			Status:  http.StatusGatewayTimeout,
+			Code:    codeQueryTimeout,
+			Message: fmt.Sprintf("the query exceeded the %s statement timeout and was stopped; narrow the search (smaller radius or limit) and retry", statementTimeout),
+		}
+	}
+
+	// 22P02: a client-supplied id (boundary, zip) does not parse as the column's type
+	if pqErr != nil && pqErr.Code == "22P02" {
+		return badRequest(codeInvalidParameter, "%s", pqErr.Message)
+	}
+
+	message := "Internal server error during query"
+	if id := requestIDFromContext(ctx); id != "" {
+		message += " (request id " + id + ")"
+	}
+	return &apiError{Status: http.StatusInternalServerError, Code: codeInternalError, Message: message}
+}
+
+// writeAPIError serializes err as {"status": "error", "code": ..., "error": ...}.
+func writeAPIError(w http.ResponseWriter, err *apiError) {
+	body, _ := json.Marshal(struct {
+		Status  string `json:"status"`
+		Code    string `json:"code"`
+		Message string `json:"error"`
+		Details any    `json:"details,omitempty"`
+	}{"error", err.Code, err.Message, err.Details})
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	setBodyHeaders(w, body)
+	w.WriteHeader(err.Status)
+	w.Write(body)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"net/http"
+)
+
+// streamInterruptedLine is the sentinel written as the final NDJSON line when
+// the row iteration fails after some features were already sent. A complete
+// export never ends with a line containing an "error" member, so consumers can
+// detect truncated exports by inspecting the last line.
+const streamInterruptedLine = `{"error": "stream_interrupted"}`
+
+// apiExportHandler serves /api/export: every feature of the dataset as
+// newline-delimited GeoJSON (one Feature object per line), streamed row by row.
+func apiExportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	ds, apiErr := lookupDataset(r.URL.Query().Get("dataset"))
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	queryStr := fmt.Sprintf(
+		`SELECT jsonb_build_object(
+			'type', 'Feature',
+			'id', %s,
+			'geometry', ST_AsGeoJSON(%s)::jsonb,
+			'properties', %s
+		)::text
+		FROM %s row
+		WHERE %s IS NOT NULL
+		ORDER BY %s;
+		`, ds.idCol(), ds.geom(), ds.propertiesExpr("row"), ds.quotedTable(), ds.geomCol(), ds.idCol())
+
+	// A full export legitimately outlasts the statement timeout of interactive queries
+	tx, err := readDB.BeginTx(r.Context(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		writeAPIError(w, queryError(r.Context(), err))
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(r.Context(), noStatementTimeout); err != nil {
+		writeAPIError(w, queryError(r.Context(), err))
+		return
+	}
+	rows, err := tx.QueryContext(r.Context(), queryStr)
+	if err != nil {
+		writeAPIError(w, queryError(r.Context(), err))
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", contentTypeNDJSON)
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	sent := 0
+	for rows.Next() {
+		var feature string
+		if err = rows.Scan(&feature); err != nil {
+			break
+		}
+		out.WriteString(feature)
+		out.WriteByte('\n')
+		sent++
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil && sent == 0 {
+		// Nothing has been written (the buffer is empty), so a normal error response still fits
+		writeAPIError(w, queryError(r.Context(), err))
+		return
+	}
+	if err != nil {
+		// Rows (and so the headers) are already on the wire, and the status
+		// code can no longer change: signal the failure in-band instead.
+		requestLogger(r.Context()).Error("export interrupted", "dataset", ds.Key, "sent", sent, "error", err)
+		out.WriteString(streamInterruptedLine)
+		out.WriteByte('\n')
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+)
+
+// invalidGeometries counts the rows of ds whose geometry fails ST_IsValid and
+// returns the reason PostGIS gives for one of them, as a hint for the logs.
+func invalidGeometries(ctx context.Context, ds *dataset) (count int64, sample string, err error) {
+	var reason sql.NullString
+	err = db.QueryRowContext(ctx, fmt.Sprintf(
+		`SELECT count(*), min(ST_IsValidReason(%[1]s))
+		FROM %[2]s
+		WHERE %[1]s IS NOT NULL AND NOT ST_IsValid(%[1]s)`,
+		ds.geomCol(), ds.quotedTable())).Scan(&count, &reason)
+	if err != nil {
+		return 0, "", fmt.Errorf("dataset %q: checking geometry validity: %w", ds.Key, err)
+	}
+	return count, reason.String, nil
+}
+
+// logInvalidGeometries reports datasets with invalid geometries at startup
+// (CHECK_GEOMETRIES=true). Such rows make ST functions error or return odd
+// results; they can be fixed with /admin/repair-geometries or masked per
+// query with "repair_geometry". The check scans every table, so it is opt-in
+// and never fails the startup.
+func logInvalidGeometries(reg *datasetRegistry) {
+	for key, ds := range reg.byKey {
+		count, sample, err := invalidGeometries(context.Background(), ds)
+		switch {
+		case err != nil:
+			log.Printf("WARNING: %v", err)
+		case count > 0:
+			log.Printf("WARNING: dataset %q has %d invalid geometries (e.g. %s); POST /admin/repair-geometries?dataset=%s fixes them",
+				key, count, sample, key)
+		}
+	}
+}
+
+// adminRepairGeometriesHandler serves /admin/repair-geometries?dataset=<key>:
+// it rewrites every invalid geometry of the dataset with ST_MakeValid, keeping
+// only the parts of the geometry's own dimension (a repaired polygon may come
+// back as a collection with stray lines). A typed column that cannot hold the
+// repaired shape (Polygon vs MultiPolygon) fails the whole update.
+func adminRepairGeometriesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+
+	ds, apiErr := lookupDataset(r.URL.Query().Get("dataset"))
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	// Repairing a whole table may outlast the statement timeout of interactive queries
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeAPIError(w, queryError(r.Context(), err))
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(r.Context(), noStatementTimeout); err != nil {
+		writeAPIError(w, queryError(r.Context(), err))
+		return
+	}
+
+	geom := ds.geomCol()
+	res, err := tx.ExecContext(r.Context(), fmt.Sprintf(
+		`UPDATE %[1]s SET %[2]s = ST_CollectionExtract(ST_MakeValid(%[2]s), ST_Dimension(%[2]s) + 1)
+		WHERE %[2]s IS NOT NULL AND NOT ST_IsValid(%[2]s)`,
+		ds.quotedTable(), geom))
+	if err != nil {
+		writeAPIError(w, queryError(r.Context(), err))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeAPIError(w, queryError(r.Context(), err))
+		return
+	}
+	repaired, _ := res.RowsAffected()
+	log.Printf("Repaired %d invalid geometries in dataset %q", repaired, ds.Key)
+
+	if repaired > 0 {
+		if err := refreshSummaries(); err != nil {
+			log.Printf("WARNING: summary refresh after geometry repair failed: %v", err)
+		}
+		// Cached tiles are keyed by the descriptor, not the data
+		if tileCacheDir != "" {
+			removeCacheDir(filepath.Join(tileCacheDir, ds.Key))
+		}
+	}
+	writeJSON(w, struct {
+		Status   string `json:"status"`
+		Dataset  string `json:"dataset"`
+		Repaired int64  `json:"repaired"`
+	}{"ok", ds.Key, repaired})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRepairSelfIntersectingPolygon(t *testing.T) {
+	conn := testDB(t)
+	ds := installFixture(t, conn, testFixture{Key: "bow_tie", GeometryType: "polygon", Rows: [][2]string{
+		{"square", "POLYGON((0 0, 1 0, 1 1, 0 1, 0 0))"},
+		{"bow tie", "POLYGON((2 0, 3 1, 3 0, 2 1, 2 0))"},
+	}})
+
+	count, sample, err := invalidGeometries(context.Background(), ds)
+	if err != nil {
+		t.Fatalf("invalidGeometries: %v", err)
+	}
+	if count != 1 || !strings.Contains(sample, "Self-intersection") {
+		t.Fatalf("got %d invalid geometries (%q), want the bow tie's self-intersection", count, sample)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/repair-geometries?dataset="+ds.Key, nil)
+	rec := httptest.NewRecorder()
+	adminRepairGeometriesHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("repair answered %d: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Repaired int64 `json:"repaired"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding repair response %q: %v", rec.Body, err)
+	}
+	if body.Repaired != 1 {
+		t.Errorf("repaired %d geometries, want 1", body.Repaired)
+	}
+
+	if count, _, err = invalidGeometries(context.Background(), ds); err != nil || count != 0 {
+		t.Errorf("after repair: %d invalid geometries (err %v), want 0", count, err)
+	}
+	// The bow tie becomes its two triangles, still a polygonal geometry
+	var geomType string
+	if err := conn.QueryRow(`SELECT GeometryType(wkb_geometry) FROM test_bow_tie WHERE name = 'bow tie'`).Scan(&geomType); err != nil {
+		t.Fatal(err)
+	}
+	if geomType != "MULTIPOLYGON" {
+		t.Errorf("repaired bow tie is a %s, want MULTIPOLYGON", geomType)
+	}
+}
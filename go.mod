@@ -2,4 +2,8 @@ module my_locator
 
 go 1.25
 
-require github.com/lib/pq v1.10.9
+require (
+	github.com/lib/pq v1.10.9
+	golang.org/x/sync v0.17.0
+	golang.org/x/text v0.30.0
+)
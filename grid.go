@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// defaultMaxGridCells caps how many cells one /api/grid request may span
+// (GRID_MAX_CELLS overrides it); every cell is a potential output feature.
+const defaultMaxGridCells = 2500
+
+var maxGridCells = defaultMaxGridCells
+
+// apiGridHandler serves /api/grid?bbox=..&cell_size=..: the features inside
+// the viewport binned into a grid of cell_size degrees, as a FeatureCollection
+// of cell polygons with a count property. It is a cheap density overview
+// (choropleth) for low zooms where individual features are noise. Only
+// non-empty cells are returned.
+func apiGridHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", contentTypeJSON)
+
+	q := r.URL.Query()
+	ds, apiErr := lookupDataset(q.Get("dataset"))
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	if q.Get("bbox") == "" {
+		writeAPIError(w, badRequest(codeInvalidBBox, "bbox=minLng,minLat,maxLng,maxLat is required"))
+		return
+	}
+	bbox, apiErr := parseBBox(q.Get("bbox"))
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	cellSize, apiErr := parseCellSize(q.Get("cell_size"), *bbox)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	count, grid, err := getGridFromDatabase(r.Context(), searchParams{Dataset: ds, BBox: bbox}, cellSize)
+	if err != nil {
+		writeAPIError(w, queryError(r.Context(), err))
+		return
+	}
+	setResultCount(r, count)
+	writeBody(w, grid)
+}
+
+// parseCellSize validates cell_size (degrees) and rejects grids over bbox
+// with more than maxGridCells cells.
+func parseCellSize(raw string, bbox tileBounds) (float64, *apiError) {
+	if raw == "" {
+		return 0, badRequest(codeInvalidCellSize, "cell_size (in degrees) is required")
+	}
+	size, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(size) || size <= 0 || size > 90 {
+		return 0, badRequest(codeInvalidCellSize, "cell_size must be a number of degrees in (0, 90], got %q", raw)
+	}
+
+	width := bbox.MaxLng - bbox.MinLng
+	if bbox.crossesAntimeridian() {
+		width += 360
+	}
+	cells := math.Ceil(width/size) * math.Ceil((bbox.MaxLat-bbox.MinLat)/size)
+	if cells > float64(maxGridCells) {
+		return 0, badRequest(codeInvalidCellSize, "cell_size %s spans %.0f cells over this bbox, the maximum is %d; use a larger cell_size",
+			raw, cells, maxGridCells)
+	}
+	return size, nil
+}
+
+// getGridFromDatabase bins the features matching p (dataset and bbox) into
+// cells of cellSize degrees. Each feature counts in the cell holding a point
+// on its surface: ST_SnapToGrid with a half-cell origin snaps that point to
+// the center of its cell, which ST_Expand grows back into the cell polygon.
+// It returns the number of non-empty cells and the FeatureCollection.
+func getGridFromDatabase(ctx context.Context, p searchParams, cellSize float64) (int, string, error) {
+	ds := p.Dataset
+	f := newSearchFilter(p)
+	size := f.args.add(cellSize)
+	var queryStr = fmt.Sprintf(
+		`SELECT count(*), jsonb_build_object(
+			'type', 'FeatureCollection',
+			'features', COALESCE(jsonb_agg(jsonb_build_object(
+				'type', 'Feature',
+				'geometry', ST_AsGeoJSON(ST_Expand(g.cell, %[1]s::float8 / 2))::jsonb,
+				'properties', jsonb_build_object('count', g.n)
+			) ORDER BY ST_Y(g.cell) DESC, ST_X(g.cell)), '[]'::jsonb)
+		)
+		FROM (
+			SELECT ST_SnapToGrid(ST_PointOnSurface(%[2]s), %[1]s::float8 / 2, %[1]s::float8 / 2, %[1]s, %[1]s) AS cell, count(*) AS n
+			FROM %[3]s
+			WHERE %[4]s
+			GROUP BY cell
+		) g;
+		`, size, ds.geom(), ds.quotedTable(), f.where())
+
+	var (
+		count int
+		grid  string
+	)
+	logQuery(ctx, "grid", queryStr, f.args)
+	err := readDB.QueryRowContext(ctx, queryStr, f.args...).Scan(&count, &grid)
+	if err == sql.ErrNoRows {
+		return 0, `{"type": "FeatureCollection", "features": []}`, nil
+	} else if err != nil {
+		return 0, "", fmt.Errorf("error scanning row: %w", err)
+	}
+	return count, grid, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// featureGroup is one bucket of a group_by search.
+type featureGroup struct {
+	Key      json.RawMessage   `json:"key"` // the column value, null for rows without one
+	Count    int               `json:"count"`
+	Features []json.RawMessage `json:"features"`
+}
+
+// groupFeatures nests the features of a search by the p.GroupBy column.
+// Groups are ordered by key (null last) and keep the search order, nearest
+// first, inside each group. Grouping happens after the scan so the SQL, and
+// the limit, stay those of a plain search.
+func groupFeatures(features string, p searchParams) (string, error) {
+	var list []map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(features), &list); err != nil {
+		return "", fmt.Errorf("decoding features: %w", err)
+	}
+
+	byKey := map[string]*featureGroup{}
+	var groups []*featureGroup
+	for _, f := range list {
+		// Flat results carry the column at the top level, GeoJSON features in
+		// properties; either way under its output name if property_names renames it
+		props := f
+		if p.Format != formatFlat {
+			props = nil
+			json.Unmarshal(f["properties"], &props)
+		}
+		key := props[p.Dataset.propertyName(p.GroupBy)]
+		if key == nil {
+			key = json.RawMessage("null")
+		}
+		g, ok := byKey[string(key)]
+		if !ok {
+			g = &featureGroup{Key: key}
+			byKey[string(key)] = g
+			groups = append(groups, g)
+		}
+		raw, err := json.Marshal(f)
+		if err != nil {
+			return "", fmt.Errorf("encoding feature: %w", err)
+		}
+		g.Features = append(g.Features, raw)
+		g.Count++
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		a, b := groups[i].Key, groups[j].Key
+		if string(a) == "null" || string(b) == "null" {
+			return string(b) == "null" && string(a) != "null"
+		}
+		return groupKeyLess(a, b)
+	})
+
+	out, err := json.Marshal(groups)
+	if err != nil {
+		return "", fmt.Errorf("encoding groups: %w", err)
+	}
+	return string(out), nil
+}
+
+// groupKeyLess orders two JSON group keys: numerically when both are
+// numbers, by their text otherwise.
+func groupKeyLess(a, b json.RawMessage) bool {
+	var x, y float64
+	if json.Unmarshal(a, &x) == nil && json.Unmarshal(b, &y) == nil {
+		return x < y
+	}
+	var s, t string
+	if json.Unmarshal(a, &s) != nil {
+		s = string(a)
+	}
+	if json.Unmarshal(b, &t) != nil {
+		t = string(b)
+	}
+	return s < t
+}
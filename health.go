@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// healthCheckTimeout bounds the database ping of a health check.
+const healthCheckTimeout = 2 * time.Second
+
+// healthzHandler serves /healthz: 200 when the primary database answers a
+// ping, 503 otherwise. HEAD requests get the same status without a body.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	status, body := http.StatusOK, `{"status": "ok"}`
+	if err := db.PingContext(ctx); err != nil {
+		status, body = http.StatusServiceUnavailable, `{"status": "unavailable"}`
+	}
+	// HEAD advertises the length of the body a GET would return
+	setBodyHeaders(w, []byte(body))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		io.WriteString(w, body)
+	}
+}
+
+// datasetReadiness is the readiness of one dataset in the /readyz body.
+type datasetReadiness struct {
+	Ready bool   `json:"ready"`
+	Error string `json:"error,omitempty"` // SQLSTATE or a short reason, details are logged
+}
+
+// readyzHandler serves /readyz: 200 once every registered dataset table can
+// be queried on the serving pool, 503 otherwise. A reachable database whose
+// tables are mid-migration (renamed, locked, missing) is alive but not ready.
+// The body reports each dataset; HEAD gets the status only.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	reg := currentDatasets()
+	datasets := make(map[string]datasetReadiness, len(reg.byKey))
+	ready := true
+	for key, ds := range reg.byKey {
+		var one int
+		err := readDB.QueryRowContext(ctx, "SELECT 1 FROM "+ds.quotedTable()+" LIMIT 1").Scan(&one)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			// An empty table is still queryable, only real errors make the dataset unready
+			requestLogger(r.Context()).Warn("dataset not ready", "dataset", key, "error", err)
+			reason := "query failed"
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) {
+				reason = "SQLSTATE " + string(pqErr.Code) + " (" + pqErr.Code.Name() + ")"
+			} else if ctx.Err() != nil {
+				reason = "timed out"
+			}
+			datasets[key] = datasetReadiness{Error: reason}
+			ready = false
+			continue
+		}
+		datasets[key] = datasetReadiness{Ready: true}
+	}
+
+	status, statusText := http.StatusOK, "ready"
+	if !ready {
+		status, statusText = http.StatusServiceUnavailable, "not_ready"
+	}
+	body, _ := json.Marshal(struct {
+		Status   string                      `json:"status"`
+		Datasets map[string]datasetReadiness `json:"datasets"`
+	}{statusText, datasets})
+	setBodyHeaders(w, body)
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
+
+// serverVersionsTTL is how long the PostgreSQL/PostGIS versions reported by
+// /health/details are reused; they only change with a database upgrade.
+const serverVersionsTTL = 10 * time.Minute
+
+// serverVersions are the database versions reported by /health/details.
+type serverVersions struct {
+	PostgreSQL string `json:"postgresql"`
+	PostGIS    string `json:"postgis"`
+}
+
+// cachedVersions holds the last successful version lookup.
+var cachedVersions struct {
+	sync.Mutex
+	serverVersions
+	fetchedAt time.Time
+}
+
+// lookupServerVersions returns the versions of the serving database, from
+// cache when the last lookup is younger than serverVersionsTTL.
+func lookupServerVersions(ctx context.Context) (serverVersions, error) {
+	cachedVersions.Lock()
+	defer cachedVersions.Unlock()
+	if !cachedVersions.fetchedAt.IsZero() && time.Since(cachedVersions.fetchedAt) < serverVersionsTTL {
+		return cachedVersions.serverVersions, nil
+	}
+
+	var v serverVersions
+	err := readDB.QueryRowContext(ctx,
+		"SELECT current_setting('server_version'), postgis_full_version()").Scan(&v.PostgreSQL, &v.PostGIS)
+	if err != nil {
+		return serverVersions{}, fmt.Errorf("looking up server versions: %w", err)
+	}
+	cachedVersions.serverVersions, cachedVersions.fetchedAt = v, time.Now()
+	return v, nil
+}
+
+// datasetDetails describes one dataset in the /health/details body.
+type datasetDetails struct {
+	Table       string     `json:"table"`
+	SRID        int        `json:"srid"`
+	Count       *int64     `json:"count"`                  // from the summary cache, null until its first refresh
+	RefreshedAt *time.Time `json:"refreshed_at,omitempty"` // when Count was computed
+}
+
+// healthDetailsHandler serves /health/details (admin only): the PostgreSQL
+// and PostGIS versions plus the table, SRID and row count of every dataset,
+// as a one-shot diagnostic of the backend. Row counts come from the summary
+// cache, so the endpoint never scans a table.
+func healthDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	status, statusText := http.StatusOK, "ok"
+	versions, err := lookupServerVersions(ctx)
+	if err != nil {
+		requestLogger(r.Context()).Warn("health details unavailable", "error", err)
+		status, statusText = http.StatusServiceUnavailable, "unavailable"
+	}
+
+	counts := make(map[string]datasetSummary)
+	for _, s := range cachedSummaries() {
+		counts[s.Key] = s
+	}
+	reg := currentDatasets()
+	datasets := make(map[string]datasetDetails, len(reg.byKey))
+	for key, ds := range reg.byKey {
+		d := datasetDetails{Table: ds.Table, SRID: ds.SRID}
+		if s, ok := counts[key]; ok {
+			d.Count, d.RefreshedAt = &s.Count, &s.RefreshedAt
+		}
+		datasets[key] = d
+	}
+
+	body, _ := json.Marshal(struct {
+		Status   string                    `json:"status"`
+		Versions *serverVersions           `json:"versions"`
+		Datasets map[string]datasetDetails `json:"datasets"`
+	}{statusText, nilIfZero(versions), datasets})
+	setBodyHeaders(w, body)
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// nilIfZero reports unknown versions as null rather than empty strings.
+func nilIfZero(v serverVersions) *serverVersions {
+	if v == (serverVersions{}) {
+		return nil
+	}
+	return &v
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // dataset timezones must resolve on images without zoneinfo
+)
+
+// Opening hours. Datasets with an hours_column get is_open_now (and closes_at
+// or next_open_at) on every search result, computed in the dataset timezone,
+// so clients can show "Open" badges without reimplementing schedule logic.
+//
+// The column holds a subset of the OpenStreetMap opening_hours syntax:
+//
+//	24/7
+//	Mo-Fr 08:00-17:00; Sa 09:00-12:00
+//	Mo,We,Fr 09:00-12:00,13:00-18:00; Su off
+//	Fr-Sa 18:00-02:00            (past midnight runs into the next day)
+//	10:00-16:00                  (no days: every day)
+//	Mo-Fr                        (no times: open all day)
+//
+// Later rules replace earlier ones for the days they name, as in OSM.
+
+// hoursWeekdays maps the OSM day abbreviations to weekday indexes, Monday first.
+var hoursWeekdays = map[string]int{"Mo": 0, "Tu": 1, "We": 2, "Th": 3, "Fr": 4, "Sa": 5, "Su": 6}
+
+// hoursRange is one opening interval in minutes after midnight. End may
+// exceed 24h for ranges running past midnight.
+type hoursRange struct {
+	Start, End int
+}
+
+// weeklyHours is a parsed schedule, indexed Monday first.
+type weeklyHours struct {
+	AlwaysOpen bool
+	Days       [7][]hoursRange
+}
+
+// parseHours parses an opening_hours value, see the syntax above.
+func parseHours(raw string) (weeklyHours, error) {
+	var h weeklyHours
+	raw = strings.TrimSpace(raw)
+	if raw == "24/7" {
+		h.AlwaysOpen = true
+		return h, nil
+	}
+	for _, rule := range strings.Split(raw, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		daySpec, times, ok := strings.Cut(rule, " ")
+		if !ok {
+			// A single token is either a day list open all day or times for every day
+			if days, err := parseHoursDays(rule); err == nil {
+				for _, d := range days {
+					h.Days[d] = []hoursRange{{0, 24 * 60}}
+				}
+				continue
+			}
+			daySpec, times = "", rule
+		}
+		days := []int{0, 1, 2, 3, 4, 5, 6}
+		if daySpec != "" {
+			var err error
+			if days, err = parseHoursDays(daySpec); err != nil {
+				return h, err
+			}
+		}
+		ranges, err := parseHoursRanges(strings.TrimSpace(times))
+		if err != nil {
+			return h, err
+		}
+		for _, d := range days {
+			h.Days[d] = ranges
+		}
+	}
+	return h, nil
+}
+
+// parseHoursDays parses a day list like "Mo-Fr", "Sa,Su" or "Mo-We,Fr".
+func parseHoursDays(spec string) ([]int, error) {
+	var days []int
+	for _, part := range strings.Split(spec, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := hoursWeekdays[from]
+		if !ok {
+			return nil, fmt.Errorf("unknown day %q", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = hoursWeekdays[to]; !ok {
+				return nil, fmt.Errorf("unknown day %q", to)
+			}
+		}
+		// Su-Tu wraps around the end of the week
+		for d := first; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseHoursRanges parses "08:00-12:00,13:00-17:00", or off/closed for none.
+func parseHoursRanges(spec string) ([]hoursRange, error) {
+	if spec == "off" || spec == "closed" {
+		return nil, nil
+	}
+	var ranges []hoursRange
+	for _, part := range strings.Split(spec, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(part), "-")
+		if !ok {
+			return nil, fmt.Errorf("time range %q needs a start and an end", part)
+		}
+		start, err := parseHoursClock(from)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseHoursClock(to)
+		if err != nil {
+			return nil, err
+		}
+		if end <= start {
+			end += 24 * 60 // runs past midnight
+		}
+		ranges = append(ranges, hoursRange{start, end})
+	}
+	return ranges, nil
+}
+
+// parseHoursClock parses HH:MM (00:00 to 24:00) into minutes after midnight.
+func parseHoursClock(s string) (int, error) {
+	hh, mm, ok := strings.Cut(s, ":")
+	h, errH := strconv.Atoi(hh)
+	m, errM := strconv.Atoi(mm)
+	if !ok || errH != nil || errM != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return h*60 + m, nil
+}
+
+// openStatus is the state of a schedule at one instant.
+type openStatus struct {
+	Open       bool
+	ClosesAt   *time.Time // next closing while open, nil when not within a week
+	NextOpenAt *time.Time // next opening while closed, nil when not within a week
+}
+
+// status evaluates h at now, which must be in the dataset timezone. Opening
+// intervals from yesterday (a late shift may still run) to a week ahead are
+// laid out on the calendar, so DST changes fall where they really are, and
+// merged, so back-to-back ranges do not report a closing in between.
+func (h weeklyHours) status(now time.Time) openStatus {
+	if h.AlwaysOpen {
+		return openStatus{Open: true}
+	}
+	type interval struct{ start, end time.Time }
+	var intervals []interval
+	y, m, d := now.Date()
+	for offset := -1; offset <= 7; offset++ {
+		day := time.Date(y, m, d+offset, 0, 0, 0, 0, now.Location())
+		weekday := (int(day.Weekday()) + 6) % 7 // Monday first
+		for _, r := range h.Days[weekday] {
+			intervals = append(intervals, interval{
+				time.Date(y, m, d+offset, 0, r.Start, 0, 0, now.Location()),
+				time.Date(y, m, d+offset, 0, r.End, 0, 0, now.Location()),
+			})
+		}
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].start.Before(intervals[j].start) })
+	var merged []interval
+	for _, iv := range intervals {
+		if n := len(merged); n > 0 && !iv.start.After(merged[n-1].end) {
+			if iv.end.After(merged[n-1].end) {
+				merged[n-1].end = iv.end
+			}
+			continue
+		}
+		merged = append(merged, iv)
+	}
+
+	horizon := time.Date(y, m, d+8, 0, 0, 0, 0, now.Location())
+	for _, iv := range merged {
+		switch {
+		case iv.end.Before(now) || iv.end.Equal(now):
+			continue
+		case iv.start.After(now):
+			start := iv.start
+			return openStatus{NextOpenAt: &start}
+		default:
+			if iv.end.Before(horizon) {
+				end := iv.end
+				return openStatus{Open: true, ClosesAt: &end}
+			}
+			return openStatus{Open: true}
+		}
+	}
+	return openStatus{}
+}
+
+// addOpenStatus adds is_open_now, plus closes_at or next_open_at (RFC 3339 in
+// the dataset timezone), to every feature with parseable hours in the
+// dataset's hours_column. Features without hours are left as they are. The
+// fields are appended to the raw objects, so the other keys keep the order
+// PostgreSQL gave them and the body stays byte-identical otherwise.
+func addOpenStatus(features string, p searchParams, now time.Time) (string, error) {
+	ds := p.Dataset
+	now = now.In(ds.location)
+	column := ds.propertyName(ds.HoursColumn)
+
+	var list []json.RawMessage
+	if err := json.Unmarshal([]byte(features), &list); err != nil {
+		return "", fmt.Errorf("decoding features: %w", err)
+	}
+	// Many features share a schedule (chains, default hours), so each value is parsed once
+	parsed := map[string]*weeklyHours{}
+	changed := false
+	for i, f := range list {
+		// Flat results carry the hours at the top level, GeoJSON features in properties
+		props, start, end := []byte(f), 0, len(f)
+		if p.Format != formatFlat {
+			var ok bool
+			if start, end, ok = objectField(f, "properties"); !ok {
+				continue
+			}
+			props = f[start:end]
+		}
+
+		// Decoded only to read the hours; the output is spliced into the raw bytes
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(props, &fields); err != nil || fields == nil {
+			continue
+		}
+		var raw string
+		if err := json.Unmarshal(fields[column], &raw); err != nil || strings.TrimSpace(raw) == "" {
+			continue
+		}
+		hours, seen := parsed[raw]
+		if !seen {
+			if h, err := parseHours(raw); err == nil {
+				hours = &h
+			}
+			parsed[raw] = hours
+		}
+		if hours == nil {
+			continue
+		}
+
+		st := hours.status(now)
+		added := fmt.Sprintf(`"is_open_now": %t`, st.Open)
+		if st.ClosesAt != nil {
+			added += fmt.Sprintf(`, "closes_at": %q`, st.ClosesAt.Format(time.RFC3339))
+		}
+		if st.NextOpenAt != nil {
+			added += fmt.Sprintf(`, "next_open_at": %q`, st.NextOpenAt.Format(time.RFC3339))
+		}
+		updated := appendObjectFields(props, added)
+		list[i] = append(append(append([]byte{}, f[:start]...), updated...), f[end:]...)
+		changed = true
+	}
+	if !changed {
+		return features, nil
+	}
+
+	// jsonb's own separator, so untouched features keep their bytes
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, f := range list {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.Write(f)
+	}
+	b.WriteByte(']')
+	return b.String(), nil
+}
+
+// objectField returns the offsets of the value of key in the JSON object obj.
+func objectField(obj []byte, key string) (start, end int, ok bool) {
+	dec := json.NewDecoder(bytes.NewReader(obj))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return 0, 0, false
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return 0, 0, false
+		}
+		// The offset is just past the key; the value follows the colon
+		start = int(dec.InputOffset())
+		for start < len(obj) && strings.IndexByte(" \t\r\n:", obj[start]) >= 0 {
+			start++
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return 0, 0, false
+		}
+		if t == key {
+			return start, int(dec.InputOffset()), true
+		}
+	}
+	return 0, 0, false
+}
+
+// appendObjectFields inserts members (`"key": value` pairs, comma separated)
+// before the closing brace of the JSON object obj.
+func appendObjectFields(obj []byte, members string) []byte {
+	body := bytes.TrimRight(obj, " \t\r\n")
+	body = body[:len(body)-1] // the closing brace
+	out := append([]byte{}, body...)
+	if len(bytes.TrimSpace(body)) > 1 {
+		out = append(out, ", "...)
+	}
+	out = append(out, members...)
+	return append(out, '}')
+}
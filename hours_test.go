@@ -0,0 +1,188 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseHours(t *testing.T) {
+	var (
+		everyDay = [7][]hoursRange{}
+		allDay   = []hoursRange{{0, 24 * 60}}
+		office   = []hoursRange{{8 * 60, 17 * 60}}
+	)
+	for d := range everyDay {
+		everyDay[d] = []hoursRange{{10 * 60, 16 * 60}}
+	}
+	for _, tc := range []struct {
+		raw  string
+		want weeklyHours
+	}{
+		{"24/7", weeklyHours{AlwaysOpen: true}},
+		{"10:00-16:00", weeklyHours{Days: everyDay}},
+		{"Mo-Fr 08:00-17:00; Sa 09:00-12:00", weeklyHours{Days: [7][]hoursRange{
+			office, office, office, office, office, {{9 * 60, 12 * 60}}, nil}}},
+		{"Mo,We 09:00-12:00,13:00-18:00", weeklyHours{Days: [7][]hoursRange{
+			{{9 * 60, 12 * 60}, {13 * 60, 18 * 60}}, nil, {{9 * 60, 12 * 60}, {13 * 60, 18 * 60}}}}},
+		// Later rules override earlier ones for their days
+		{"Mo-Fr 08:00-17:00; We off", weeklyHours{Days: [7][]hoursRange{office, office, nil, office, office}}},
+		{"Mo-Fr 08:00-17:00; Fr closed", weeklyHours{Days: [7][]hoursRange{office, office, office, office, nil}}},
+		{"Mo-Su 08:00-17:00; Sa 10:00-12:00", weeklyHours{Days: [7][]hoursRange{
+			office, office, office, office, office, {{10 * 60, 12 * 60}}, office}}},
+		// Su-Tu wraps around the end of the week
+		{"Su-Tu 10:00-12:00", weeklyHours{Days: [7][]hoursRange{
+			{{10 * 60, 12 * 60}}, {{10 * 60, 12 * 60}}, nil, nil, nil, nil, {{10 * 60, 12 * 60}}}}},
+		// Past midnight ends the next day
+		{"Fr-Sa 18:00-02:00", weeklyHours{Days: [7][]hoursRange{4: {{18 * 60, 26 * 60}}, 5: {{18 * 60, 26 * 60}}}}},
+		{"Mo 00:00-24:00", weeklyHours{Days: [7][]hoursRange{allDay}}},
+		// A day list alone is open all day
+		{"Sa,Su", weeklyHours{Days: [7][]hoursRange{5: allDay, 6: allDay}}},
+		{"Mo-Fr; We 08:00-17:00", weeklyHours{Days: [7][]hoursRange{allDay, allDay, office, allDay, allDay}}},
+		{"off", weeklyHours{}},
+		{"", weeklyHours{}},
+	} {
+		got, err := parseHours(tc.raw)
+		if err != nil {
+			t.Errorf("parseHours(%q): %v", tc.raw, err)
+		} else if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseHours(%q) = %+v, want %+v", tc.raw, got, tc.want)
+		}
+	}
+
+	for _, raw := range []string{"Xx 10:00-12:00", "Mo-Xx 10:00-12:00", "Mo 10:00", "Mo 10-12", "Mo 25:00-26:00", "Mo 10:60-11:00", "Mo-Fr sometimes"} {
+		if got, err := parseHours(raw); err == nil {
+			t.Errorf("parseHours(%q) = %+v, want an error", raw, got)
+		}
+	}
+}
+
+func TestWeeklyHoursStatus(t *testing.T) {
+	loc, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	for _, tc := range []struct {
+		hours, now string
+		open       bool
+		change     string // closes_at or next_open_at, RFC 3339, "" for none
+	}{
+		// 2026-10-12 is a Monday
+		{"Mo-Fr 08:00-17:00; Sa 09:00-12:00", "2026-10-14 10:00", true, "2026-10-14T17:00:00-05:00"},
+		{"Mo-Fr 08:00-17:00; Sa 09:00-12:00", "2026-10-14 17:00", false, "2026-10-15T08:00:00-05:00"},
+		{"Mo-Fr 08:00-17:00; Sa 09:00-12:00", "2026-10-17 13:00", false, "2026-10-19T08:00:00-05:00"},
+		{"Mo-Fr 08:00-17:00; We off", "2026-10-14 10:00", false, "2026-10-15T08:00:00-05:00"},
+		{"Mo-Su 08:00-17:00; Sa 10:00-12:00", "2026-10-17 09:00", false, "2026-10-17T10:00:00-05:00"},
+		{"Su-Tu 10:00-12:00", "2026-10-12 11:00", true, "2026-10-12T12:00:00-05:00"},
+		{"Su-Tu 10:00-12:00", "2026-10-14 11:00", false, "2026-10-18T10:00:00-05:00"},
+		// Yesterday's late shift is still running
+		{"Fr-Sa 18:00-02:00", "2026-10-17 01:00", true, "2026-10-17T02:00:00-05:00"},
+		{"Fr-Sa 18:00-02:00", "2026-10-17 20:00", true, "2026-10-18T02:00:00-05:00"},
+		{"Fr-Sa 18:00-02:00", "2026-10-18 03:00", false, "2026-10-23T18:00:00-05:00"},
+		// Back-to-back ranges and days merge
+		{"Mo 08:00-12:00,12:00-17:00", "2026-10-12 11:00", true, "2026-10-12T17:00:00-05:00"},
+		{"Mo-Fr", "2026-10-14 23:00", true, "2026-10-17T00:00:00-05:00"},
+		{"Mo-Fr", "2026-10-18 12:00", false, "2026-10-19T00:00:00-05:00"},
+		{"24/7", "2026-10-14 10:00", true, ""},
+		{"off", "2026-10-14 10:00", false, ""},
+		// Clocks go forward at 02:00 on Sunday 2026-03-08
+		{"Su 01:00-04:00", "2026-03-08 01:30", true, "2026-03-08T04:00:00-05:00"},
+		{"Su 09:00-17:00", "2026-03-07 20:00", false, "2026-03-08T09:00:00-05:00"},
+		{"Sa 22:00-03:00", "2026-03-08 01:00", true, "2026-03-08T03:00:00-05:00"},
+		// and back at 02:00 on Sunday 2026-11-01
+		{"Su 00:00-12:00", "2026-10-31 23:00", false, "2026-11-01T00:00:00-05:00"},
+		{"Su 00:00-12:00", "2026-11-01 10:00", true, "2026-11-01T12:00:00-06:00"},
+	} {
+		h, err := parseHours(tc.hours)
+		if err != nil {
+			t.Fatalf("parseHours(%q): %v", tc.hours, err)
+		}
+		st := h.status(at(tc.now))
+		var change string
+		switch {
+		case st.ClosesAt != nil:
+			change = st.ClosesAt.Format(time.RFC3339)
+		case st.NextOpenAt != nil:
+			change = st.NextOpenAt.Format(time.RFC3339)
+		}
+		if st.Open != tc.open || change != tc.change || (st.Open && st.NextOpenAt != nil) || (!st.Open && st.ClosesAt != nil) {
+			t.Errorf("%q at %s: open %v, change %q, want open %v, change %q", tc.hours, tc.now, st.Open, change, tc.open, tc.change)
+		}
+	}
+}
+
+func TestAddOpenStatus(t *testing.T) {
+	loc, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := &dataset{Key: "shops", HoursColumn: "hours", location: loc}
+	now := time.Date(2026, 10, 14, 15, 0, 0, 0, time.UTC) // 10:00 in Chicago
+
+	for _, tc := range []struct {
+		format, in, want string
+	}{
+		{formatGeoJSON,
+			`[{"type": "Feature", "geometry": null, "properties": {}}, {"id": 3, "type": "Feature", "properties": {"name": "a", "hours": "24/7"}, "geometry": null}]`,
+			`[{"type": "Feature", "geometry": null, "properties": {}}, {"id": 3, "type": "Feature", "properties": {"name": "a", "hours": "24/7", "is_open_now": true}, "geometry": null}]`},
+		{formatGeoJSON,
+			`[{"type": "Feature", "properties": {"hours": "Mo-Fr 08:00-09:00"}}]`,
+			`[{"type": "Feature", "properties": {"hours": "Mo-Fr 08:00-09:00", "is_open_now": false, "next_open_at": "2026-10-15T08:00:00-05:00"}}]`},
+		{formatFlat,
+			`[{"hours": "Mo-Fr 08:00-17:00", "lat": 1, "lng": 2}, {}]`,
+			`[{"hours": "Mo-Fr 08:00-17:00", "lat": 1, "lng": 2, "is_open_now": true, "closes_at": "2026-10-14T17:00:00-05:00"}, {}]`},
+		// Nothing to add: the body is passed through untouched
+		{formatGeoJSON, `[{"properties": {"hours": "not hours"}},{"properties": null}]`, `[{"properties": {"hours": "not hours"}},{"properties": null}]`},
+		{formatFlat, `[]`, `[]`},
+	} {
+		got, err := addOpenStatus(tc.in, searchParams{Dataset: ds, Format: tc.format}, now)
+		if err != nil {
+			t.Errorf("addOpenStatus(%s): %v", tc.in, err)
+		} else if got != tc.want {
+			t.Errorf("addOpenStatus(%s)\n got %s\nwant %s", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestObjectField(t *testing.T) {
+	for _, tc := range []struct {
+		obj, key, want string // want "" when the key is not found
+	}{
+		{`{"properties": {"a": 1}}`, "properties", `{"a": 1}`},
+		{`{"a":{"properties":1},"properties":{"b":2}}`, "properties", `{"b":2}`},
+		{`{ "id" : "x" , "n": 1 }`, "id", `"x"`},
+		{`{"a": [1, {"properties": 2}]}`, "properties", ""},
+		{`{}`, "properties", ""},
+		{`[{"properties": {}}]`, "properties", ""},
+		{`{"properties": `, "properties", ""},
+	} {
+		start, end, ok := objectField([]byte(tc.obj), tc.key)
+		var got string
+		if ok {
+			got = tc.obj[start:end]
+		}
+		if got != tc.want {
+			t.Errorf("objectField(%s, %q) = %q, want %q", tc.obj, tc.key, got, tc.want)
+		}
+	}
+}
+
+func TestAppendObjectFields(t *testing.T) {
+	for _, tc := range []struct{ obj, want string }{
+		{`{}`, `{"k": 1}`},
+		{`{ }`, `{ "k": 1}`},
+		{`{"a": 2}`, `{"a": 2, "k": 1}`},
+		{"{\"a\": 2}\n", `{"a": 2, "k": 1}`},
+	} {
+		if got := string(appendObjectFields([]byte(tc.obj), `"k": 1`)); got != tc.want {
+			t.Errorf("appendObjectFields(%q) = %q, want %q", tc.obj, got, tc.want)
+		}
+	}
+}
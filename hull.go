@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+)
+
+// apiHullHandler serves /api/hull: the convex hull of the features matching a
+// search (same parameters as /api/search), for drawing a coverage area.
+// Degenerate results are returned as-is: no match gives a null hull, one
+// feature a Point and two features a LineString.
+func apiHullHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", contentTypeJSON)
+
+	params, apiErr := parseSearchParams(r.URL.Query())
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	ctx := r.Context()
+	if apiErr := resolveCenter(ctx, &params); apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	count, hull, err := getHullFromDatabase(ctx, params)
+	if err != nil {
+		writeAPIError(w, queryError(r.Context(), err))
+		return
+	}
+	setResultCount(r, count)
+
+	geometry := "null"
+	if hull.Valid {
+		geometry = hull.String
+	}
+	writeBody(w, fmt.Sprintf(`{"status": "ok", "count": %d, "hull": %s}`, count, geometry))
+}
+
+// getHullFromDatabase returns how many features matched and the GeoJSON of
+// their convex hull (NULL when nothing matched).
+func getHullFromDatabase(ctx context.Context, p searchParams) (int, sql.NullString, error) {
+	ds := p.Dataset
+	f := newSearchFilter(p)
+	var queryStr = fmt.Sprintf(
+		`SELECT count(*), ST_AsGeoJSON(ST_ConvexHull(ST_Collect(%s)))
+		FROM %s
+		WHERE %s;
+		`, ds.geom(), ds.quotedTable(), f.where())
+
+	var (
+		count int
+		hull  sql.NullString
+	)
+	logQuery(ctx, "hull", queryStr, f.args)
+	if err := readDB.QueryRowContext(ctx, queryStr, f.args...).Scan(&count, &hull); err != nil {
+		return 0, hull, fmt.Errorf("error scanning row: %w", err)
+	}
+	return count, hull, nil
+}
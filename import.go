@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Import limits. The download size is configurable with IMPORT_MAX_BYTES.
+const (
+	defaultImportMaxBytes = 50 << 20
+	importTimeout         = 2 * time.Minute
+)
+
+var importMaxBytes = defaultImportMaxBytes
+
+// importRequest is the JSON body of /admin/import.
+type importRequest struct {
+	Dataset string `json:"dataset"`
+	URL     string `json:"url"`
+	DryRun  bool   `json:"dry_run"`
+}
+
+// importResult reports what an import did (or, for a dry run, would do).
+type importResult struct {
+	Status       string `json:"status"`
+	Dataset      string `json:"dataset"`
+	DryRun       bool   `json:"dry_run"`
+	Features     int    `json:"features"`      // features read from the file
+	Imported     int64  `json:"imported"`      // rows staged (and written unless dry run)
+	Skipped      int    `json:"skipped"`       // features without a geometry
+	PreviousRows int64  `json:"previous_rows"` // rows in the table before the import
+}
+
+// adminImportHandler serves /admin/import: it downloads a GeoJSON
+// FeatureCollection and replaces the contents of a dataset table with it.
+// Features are first loaded into a staging table, so a file whose properties
+// do not fit the table schema fails before anything is touched; the swap
+// itself (DELETE + INSERT from staging) is a single transaction, so readers
+// see either the old or the new rows. With dry_run the transaction is rolled
+// back after staging and only the counts are reported.
+func adminImportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+
+	body, apiErr := readRequestBody(w, r)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	var req importRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeAPIError(w, badRequest(codeInvalidParameter, "invalid import request body: %s", err))
+		return
+	}
+	ds, apiErr := lookupDataset(req.Dataset)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		writeAPIError(w, badRequest(codeInvalidParameter, "url must be an absolute http(s) URL, got %q", req.URL))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), importTimeout)
+	defer cancel()
+
+	features, err := downloadFeatures(ctx, req.URL)
+	if err != nil {
+		writeAPIError(w, &apiError{Status: http.StatusBadGateway, Code: codeImportFailed, Message: err.Error()})
+		return
+	}
+
+	result, err := importFeatures(ctx, ds, features, req.DryRun)
+	if err != nil {
+		writeAPIError(w, &apiError{Status: http.StatusUnprocessableEntity, Code: codeImportFailed, Message: err.Error()})
+		return
+	}
+
+	if !req.DryRun {
+		log.Printf("Imported %d features into dataset %q from %s (%d rows replaced)", result.Imported, ds.Key, req.URL, result.PreviousRows)
+		if err := refreshSummaries(); err != nil {
+			log.Printf("WARNING: summary refresh after import failed: %v", err)
+		}
+		// Cached tiles are keyed by the descriptor, not the data
+		if tileCacheDir != "" {
+			removeCacheDir(filepath.Join(tileCacheDir, ds.Key))
+		}
+	}
+	writeJSON(w, result)
+}
+
+// geoJSONFeature is the subset of a GeoJSON Feature the importer needs.
+type geoJSONFeature struct {
+	Geometry   json.RawMessage            `json:"geometry"`
+	Properties map[string]json.RawMessage `json:"properties"`
+}
+
+// downloadFeatures fetches and decodes a FeatureCollection, refusing files
+// larger than importMaxBytes.
+func downloadFeatures(ctx context.Context, rawURL string) ([]geoJSONFeature, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("building download request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("downloading %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading %s: unexpected status %s", rawURL, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(importMaxBytes)+1))
+	if err != nil {
+		return nil, fmt.Errorf("downloading %s: %w", rawURL, err)
+	}
+	if len(body) > importMaxBytes {
+		return nil, fmt.Errorf("downloading %s: file exceeds the %d byte import limit", rawURL, importMaxBytes)
+	}
+
+	var fc struct {
+		Type     string           `json:"type"`
+		Features []geoJSONFeature `json:"features"`
+	}
+	if err := json.Unmarshal(body, &fc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", rawURL, err)
+	}
+	if fc.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("parsing %s: expected a FeatureCollection, got type %q", rawURL, fc.Type)
+	}
+	return fc.Features, nil
+}
+
+// importFeatures stages features in a temporary copy of the dataset table and,
+// unless dryRun, swaps them in for the current rows.
+func importFeatures(ctx context.Context, ds *dataset, features []geoJSONFeature, dryRun bool) (importResult, error) {
+	result := importResult{Status: "ok", Dataset: ds.Key, DryRun: dryRun, Features: len(features)}
+
+	// Geometry-less features cannot be searched; feature ids are kept only when every feature has one
+	rows := make([]map[string]json.RawMessage, 0, len(features))
+	withIDs := len(features) > 0
+	for _, f := range features {
+		if len(f.Geometry) == 0 || string(f.Geometry) == "null" {
+			result.Skipped++
+			continue
+		}
+		row := map[string]json.RawMessage{}
+		for k, v := range f.Properties {
+			row[k] = v
+		}
+		if _, ok := row[ds.IDColumn]; !ok {
+			withIDs = false
+		}
+		row[ds.GeomColumn] = f.Geometry
+		rows = append(rows, row)
+	}
+	payload, err := json.Marshal(rows)
+	if err != nil {
+		return result, fmt.Errorf("encoding features: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, fmt.Errorf("starting import transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, noStatementTimeout); err != nil {
+		return result, fmt.Errorf("lifting statement timeout: %w", err)
+	}
+
+	var columns []string
+	err = tx.QueryRowContext(ctx,
+		`SELECT array_agg(attname::text ORDER BY attnum)
+		FROM pg_attribute
+		WHERE attrelid = $1::regclass AND attnum > 0 AND NOT attisdropped`,
+		ds.quotedTable()).Scan(pq.Array(&columns))
+	if err != nil {
+		return result, fmt.Errorf("reading columns of %s: %w", ds.Table, err)
+	}
+	// Without ids in the file the table's default (usually a sequence) numbers the rows
+	var quoted, fromRecord []string
+	for _, c := range columns {
+		if c == ds.IDColumn && !withIDs {
+			continue
+		}
+		quoted = append(quoted, pq.QuoteIdentifier(c))
+		fromRecord = append(fromRecord, "r."+pq.QuoteIdentifier(c))
+	}
+	columnList := strings.Join(quoted, ", ")
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(
+		`CREATE TEMP TABLE import_staging (LIKE %s INCLUDING DEFAULTS) ON COMMIT DROP`, ds.quotedTable())); err != nil {
+		return result, fmt.Errorf("creating staging table: %w", err)
+	}
+
+	// jsonb_populate_record maps properties onto the table's columns and types;
+	// the geometry is converted to the column's SRID and handed over as hex EWKB
+	geometry := fmt.Sprintf("encode(ST_AsEWKB(ST_Transform(ST_SetSRID(ST_GeomFromGeoJSON(f -> %[1]s), 4326), %[2]d)), 'hex')",
+		pq.QuoteLiteral(ds.GeomColumn), ds.SRID)
+	res, err := tx.ExecContext(ctx, fmt.Sprintf(
+		`INSERT INTO import_staging (%[1]s)
+		SELECT %[2]s
+		FROM jsonb_array_elements($1::jsonb) AS f,
+			jsonb_populate_record(NULL::import_staging, f || jsonb_build_object(%[3]s, %[4]s)) AS r`,
+		columnList, strings.Join(fromRecord, ", "), pq.QuoteLiteral(ds.GeomColumn), geometry), string(payload))
+	if err != nil {
+		return result, fmt.Errorf("staging features: %w", err)
+	}
+	if result.Imported, err = res.RowsAffected(); err != nil {
+		return result, fmt.Errorf("staging features: %w", err)
+	}
+
+	if err := tx.QueryRowContext(ctx, "SELECT count(*) FROM "+ds.quotedTable()).Scan(&result.PreviousRows); err != nil {
+		return result, fmt.Errorf("counting current rows: %w", err)
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+ds.quotedTable()); err != nil {
+		return result, fmt.Errorf("clearing %s: %w", ds.Table, err)
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (%s) SELECT %s FROM import_staging`,
+		ds.quotedTable(), columnList, columnList)); err != nil {
+		return result, fmt.Errorf("swapping in imported rows: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return result, fmt.Errorf("committing import: %w", err)
+	}
+	return result, nil
+}
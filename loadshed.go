@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// defaultQueueTimeout is how long a request may wait for a free query slot
+// before it is shed with a 503 (QUEUE_TIMEOUT overrides it).
+const defaultQueueTimeout = 500 * time.Millisecond
+
+var queueTimeout = defaultQueueTimeout
+
+// querySlots bounds the number of in-flight requests of one endpoint category
+// (search, tiles, export), so excess requests wait here (briefly) instead of
+// piling up inside database/sql where they would only time out later.
+// Separate categories keep a flood of expensive requests of one kind from
+// starving the others.
+type querySlots struct {
+	name     string
+	slots    chan struct{}
+	maxQueue int64 // waiting requests beyond this are shed immediately
+	waiting  atomic.Int64
+}
+
+// newQuerySlots reads the limits of category name from CONCURRENCY_<NAME>
+// and QUEUE_DEPTH_<NAME>, defaulting to the given values.
+func newQuerySlots(name string, concurrency, queueDepth int) *querySlots {
+	env := envName(name)
+	return &querySlots{
+		name:     name,
+		slots:    make(chan struct{}, envInt("CONCURRENCY_"+env, concurrency)),
+		maxQueue: int64(envInt("QUEUE_DEPTH_"+env, queueDepth)),
+	}
+}
+
+// withQuerySlot sheds load when the queue of s is full, or when every slot
+// stays busy for queueTimeout, answering 503 with a Retry-After header
+// instead of queuing indefinitely.
+func (s *querySlots) withQuerySlot(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			if !s.wait(r.Context()) {
+				stats := readDB.Stats()
+				requestLogger(r.Context()).Warn("shedding request: no query slot available",
+					"category", s.name, "method", r.Method, "path", r.URL.Path, "queue_timeout", queueTimeout.String(),
+					"waiting", s.waiting.Load(), "in_use", stats.InUse, "wait_count", stats.WaitCount)
+
+				// Suggest a retry once the current queries have had time to drain
+				w.Header().Set("Retry-After", strconv.Itoa(int(queueTimeout/time.Second)+1))
+				writeAPIError(w, &apiError{
+					Status:  http.StatusServiceUnavailable,
+					Code:    codeOverloaded,
+					Message: "server is busy, please retry shortly",
+				})
+				return
+			}
+		}
+		defer func() { <-s.slots }()
+
+		h(w, r)
+	}
+}
+
+// wait queues for a slot for at most queueTimeout, reporting whether one was acquired.
+func (s *querySlots) wait(ctx context.Context) bool {
+	if s.waiting.Add(1) > s.maxQueue {
+		s.waiting.Add(-1)
+		return false
+	}
+	defer s.waiting.Add(-1)
+
+	ctx, cancel := context.WithTimeout(ctx, queueTimeout)
+	defer cancel()
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// distanceSymbols are the unit suffixes of formatted distances, keyed like distanceUnits.
+var distanceSymbols = map[string]string{
+	"km": "km",
+	"mi": "mi",
+}
+
+// requestLocale picks the locale for formatted output: the explicit `locale`
+// parameter when given, else the client's preferred Accept-Language, else English.
+func requestLocale(r *http.Request, p searchParams) language.Tag {
+	if p.Locale != language.Und {
+		return p.Locale
+	}
+	if tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language")); err == nil && len(tags) > 0 {
+		return tags[0]
+	}
+	return language.English
+}
+
+// formatDistances adds a `<distance column>_text` property with the distance
+// formatted for tag (e.g. "1.3 km" or "1,3 km") to every feature. The raw
+// numeric property is left untouched.
+func formatDistances(features string, p searchParams, tag language.Tag) (string, error) {
+	du := distanceUnits[p.Unit]
+	printer := message.NewPrinter(tag)
+
+	var list []map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(features), &list); err != nil {
+		return "", fmt.Errorf("decoding features: %w", err)
+	}
+	for _, f := range list {
+		// Flat results carry the distance at the top level, GeoJSON features in properties
+		props := f
+		if p.Format != formatFlat {
+			props = nil
+			if err := json.Unmarshal(f["properties"], &props); err != nil || props == nil {
+				continue
+			}
+		}
+
+		var distance float64
+		if err := json.Unmarshal(props[du.column], &distance); err != nil {
+			continue
+		}
+		text := printer.Sprintf("%v %s", number.Decimal(distance, number.MaxFractionDigits(distancePrecision)), distanceSymbols[p.Unit])
+		props[du.column+"_text"], _ = json.Marshal(text)
+
+		if p.Format != formatFlat {
+			f["properties"], _ = json.Marshal(props)
+		}
+	}
+
+	out, err := json.Marshal(list)
+	if err != nil {
+		return "", fmt.Errorf("encoding features: %w", err)
+	}
+	return string(out), nil
+}
@@ -1,34 +1,185 @@
 package main
 
 import (
-	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 	
 	// Use the recommended standard PostgreSQL driver
 	// Run: go get github.com/lib/pq
 	_ "github.com/lib/pq"
 )
 
-// Global database connection pool
-var db *sql.DB
-
 func main() {
 	// 1. Initialize Database Connection
 	// This function handles connection both locally (via Proxy) and on App Engine (via Unix socket).
+	if err := validateConfig(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	// LOG_LEVEL=debug enables per-query logging (SQL plus redacted parameters)
+	level, err := parseLogLevel(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	slog.SetLogLoggerLevel(level)
+	// Dataset descriptors come from DATASETS_FILE, or the built-in recycling layer
+	if path := os.Getenv("DATASETS_FILE"); path != "" {
+		reg, err := loadDatasets(path)
+		if err != nil {
+			log.Fatalf("Failed to load datasets: %v", err)
+		}
+		registry.Store(reg)
+	}
+	// Bound each connection attempt and retry cold-start failures with backoff
+	connectTimeout = envDuration("DB_CONNECT_TIMEOUT", defaultConnectTimeout)
+	connectAttempts = envInt("DB_CONNECT_ATTEMPTS", defaultConnectAttempts)
+	// Server-side limit per statement; overruns answer 504 query_timeout
+	statementTimeout = envDuration("DB_STATEMENT_TIMEOUT", 0)
+
+	// Pooled connections are recycled after DB_CONN_MAX_LIFETIME, ± a jitter
+	connMaxLifetime = envDuration("DB_CONN_MAX_LIFETIME", defaultConnMaxLifetime)
+	connLifetimeJitterPct = min(envInt("DB_CONN_LIFETIME_JITTER_PERCENT", defaultConnLifetimeJitterPct), 50)
 	if err := initDB(); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
+	if err := validateDatasets(currentDatasets()); err != nil {
+		log.Fatalf("Invalid dataset configuration: %v", err)
+	}
+	// Optional scan for geometries failing ST_IsValid (logged, never fatal)
+	if os.Getenv("CHECK_GEOMETRIES") == "true" {
+		logInvalidGeometries(currentDatasets())
+	}
+
+	// How long a request may wait for a free query slot before being shed with a 503
+	queueTimeout = envDuration("QUEUE_TIMEOUT", defaultQueueTimeout)
+
+	// How long a query may wait for a pooled connection before failing with pool_exhausted
+	acquireTimeout = envDuration("CONN_ACQUIRE_TIMEOUT", defaultAcquireTimeout)
+
+	// Bounds for auto_expand=true searches
+	autoExpandMaxSteps = envInt("AUTO_EXPAND_MAX_STEPS", defaultAutoExpandMaxSteps)
+	autoExpandMaxRadius = envInt("AUTO_EXPAND_MAX_RADIUS", defaultAutoExpandMaxRadius)
+
+	// Searches with a limit above this are streamed row by row
+	streamThreshold = envInt("STREAM_THRESHOLD", defaultStreamThreshold)
+
+	// Largest request body accepted by POST endpoints (413 above it)
+	maxBodyBytes = envInt("MAX_BODY_BYTES", defaultMaxBodyBytes)
+
+	// Largest GeoJSON file /admin/import will download
+	importMaxBytes = envInt("IMPORT_MAX_BYTES", defaultImportMaxBytes)
+
+	// Decimals kept in distance properties (raw doubles carry floating-point noise)
+	distancePrecision = envNonNegativeInt("DISTANCE_PRECISION", defaultDistancePrecision)
+
+	// Highest estimated search cost accepted (0 disables the check)
+	queryCostBudget = envNonNegativeInt("QUERY_COST_BUDGET", defaultQueryCostBudget)
+
+	// Per-key / per-IP request counts for /admin/usage, reset every USAGE_WINDOW
+	startUsageAccounting(envDuration("USAGE_WINDOW", defaultUsageWindow))
+
+	// Log 1 in N successful requests; errors are always logged
+	logSampleRate = envInt("LOG_SAMPLE_RATE", 1)
+
+	// Most cells a single /api/grid request may span
+	maxGridCells = envInt("GRID_MAX_CELLS", defaultMaxGridCells)
+
+	// Periodically log how often searches hit their limit
+	startCappedReporter(envDuration("CAPPED_REPORT_INTERVAL", defaultCappedReportInterval))
+
+	// Keep dataset metadata (counts, extents) warm in memory
+	startSummaryRefresher(envDuration("SUMMARY_REFRESH_INTERVAL", defaultSummaryRefreshInterval))
+
+	// TRAILING_SLASH=redirect answers "/api/search/" with a 308 instead of serving it
+	trailingSlashRedirect = os.Getenv("TRAILING_SLASH") == "redirect"
+
+	// API keys (X-API-Key) unlocking datasets marked "public": false
+	loadAPIKeys()
+
+	// Per-category concurrency limits (CONCURRENCY_<CATEGORY>, QUEUE_DEPTH_<CATEGORY>),
+	// so tile floods cannot starve searches and the reverse
+	searchSlots := newQuerySlots("search", maxOpenConns, 2*maxOpenConns)
+	tileSlots := newQuerySlots("tiles", maxOpenConns/2, 4*maxOpenConns)
+	countSlots := newQuerySlots("count", 2, 2*maxOpenConns)
+	exportSlots := newQuerySlots("export", 2, 2)
 
 	// 2. Set up HTTP Handlers
-	// Serves the frontend static files (HTML, CSS, JS) from the 'static' directory.
-	http.Handle("/", http.FileServer(http.Dir("static")))
+	// Every API route is wrapped in allowMethods so unsupported methods get a 405.
+	// Serves the frontend static files (HTML, CSS, JS) from STATIC_DIR (default 'static'),
+	// with index.html as the fallback for client-side routes.
+	staticDir := os.Getenv("STATIC_DIR")
+	if staticDir == "" {
+		staticDir = "static"
+	}
+	http.Handle("/", spaHandler(staticDir))
 
 	// API endpoint for store search - This name MUST match the BACKEND_API_URL in app.js
-	http.HandleFunc("/api/search", apiSearchHandler)
+	// HEAD only counts, so it gets its own cheap slots instead of queuing behind full searches
+	searchHandler, countHandler := searchSlots.withQuerySlot(apiSearchHandler), countSlots.withQuerySlot(apiSearchHandler)
+	http.HandleFunc("/api/search", allowMethods(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			countHandler(w, r)
+			return
+		}
+		searchHandler(w, r)
+	}, http.MethodGet, http.MethodHead, http.MethodOptions))
+
+	// Nearest features across several datasets, merged into one layer
+	http.HandleFunc("/api/search/multi", allowMethods(searchSlots.withQuerySlot(apiSearchMultiHandler), http.MethodGet, http.MethodOptions))
+
+	// Raw GeoJSON for a single XYZ tile, mainly for inspecting what a tile contains
+	http.HandleFunc("/api/tile/{z}/{x}/{y}", allowMethods(tileSlots.withQuerySlot(apiTileGeoJSONHandler), http.MethodGet, http.MethodOptions))
+
+	// Convex hull of the features matching a search, for drawing coverage areas
+	http.HandleFunc("/api/hull", allowMethods(searchSlots.withQuerySlot(apiHullHandler), http.MethodGet, http.MethodOptions))
+	// Feature counts per grid cell over a viewport, for low-zoom density maps
+	http.HandleFunc("/api/grid", allowMethods(searchSlots.withQuerySlot(apiGridHandler), http.MethodGet, http.MethodOptions))
+
+	// Aggregates (min/max/avg/sum) of a numeric property over a search, for dashboards
+	http.HandleFunc("/api/stats", allowMethods(searchSlots.withQuerySlot(apiStatsHandler), http.MethodGet, http.MethodOptions))
+
+	// Nearest feature of each category, for "nearest X, nearest Y" dashboards
+	http.HandleFunc("/api/nearest-per-category", allowMethods(searchSlots.withQuerySlot(apiNearestPerCategoryHandler), http.MethodGet, http.MethodOptions))
+
+	// Mapbox Vector Tiles, cached on disk when TILE_CACHE_DIR is set
+	tileCacheDir = os.Getenv("TILE_CACHE_DIR")
+	pruneTileCache()
+	http.HandleFunc("/tiles/{z}/{x}/{y}", allowMethods(tileSlots.withQuerySlot(apiTileMVTHandler), http.MethodGet, http.MethodOptions))
+
+	// Features along a route (GeoJSON LineString plus buffer); POST for long routes
+	http.HandleFunc("/api/corridor", allowMethods(searchSlots.withQuerySlot(apiCorridorHandler), http.MethodGet, http.MethodPost, http.MethodOptions))
+
+	// Full dataset export as newline-delimited GeoJSON
+	http.HandleFunc("/api/export", allowMethods(exportSlots.withQuerySlot(apiExportHandler), http.MethodGet, http.MethodOptions))
+
+	// Liveness probe; HEAD gives load balancers a body-less check
+	http.HandleFunc("/healthz", allowMethods(healthzHandler, http.MethodGet, http.MethodHead))
+
+	// Readiness probe: every dataset table must answer a query
+	http.HandleFunc("/readyz", allowMethods(readyzHandler, http.MethodGet, http.MethodHead))
+
+	// API index: endpoints and active datasets, for discovery
+	http.HandleFunc("/api", allowMethods(apiIndexHandler, http.MethodGet, http.MethodOptions))
+	http.HandleFunc("/api/{$}", allowMethods(apiIndexHandler, http.MethodGet, http.MethodOptions))
+
+	// Catalog of the machine-readable error codes
+	http.HandleFunc("/api/errors", allowMethods(apiErrorsHandler, http.MethodGet, http.MethodOptions))
+
+	// Dataset metadata served from the in-memory summary cache
+	http.HandleFunc("/api/datasets", allowMethods(apiDatasetsHandler, http.MethodGet, http.MethodOptions))
+
+	// Admin endpoints (require ADMIN_TOKEN)
+	http.HandleFunc("/admin/refresh", allowMethods(requireAdmin(adminRefreshHandler), http.MethodPost))
+	http.HandleFunc("/admin/reload", allowMethods(requireAdmin(adminReloadHandler), http.MethodPost))
+	http.HandleFunc("/admin/import", allowMethods(requireAdmin(adminImportHandler), http.MethodPost))
+	http.HandleFunc("/admin/repair-geometries", allowMethods(requireAdmin(adminRepairGeometriesHandler), http.MethodPost))
+	http.HandleFunc("/health/details", allowMethods(requireAdmin(healthDetailsHandler), http.MethodGet))
+	http.HandleFunc("/admin/usage", allowMethods(requireAdmin(adminUsageHandler), http.MethodGet))
 
 	// 3. Start the Server
 	port := os.Getenv("PORT")
@@ -37,157 +188,197 @@ func main() {
 	}
 
 	log.Printf("Store Locator Backend (Go) listening on port %s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	// Request logging wraps gzip so it sees both the raw and compressed sizes;
+	// the request id is assigned outermost so every log line of a request can carry it.
+	// Private datasets are gated innermost, so even rejected requests are logged;
+	// trailing slashes are normalized before the mux matches routes.
+	handler := withRequestID(withLogging(withGzip(withDatasetAccess(withTrailingSlash(http.DefaultServeMux)))))
+	if err := http.ListenAndServe(":"+port, handler); err != nil {
 		log.Fatal(err)
 	}
 }
 
-// initDB establishes the connection to the Cloud SQL instance
-func initDB() error {
-	// Credentials retrieved from App Engine environment variables (or local shell)
-	instanceConnectionName := os.Getenv("INSTANCE_CONNECTION_NAME")
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_NAME")
-
-	// Fallback/Local values
-	if dbUser == "" { dbUser = "postgres" }
-	if dbName == "" { dbName = "recycling_db" }
-
-	var connectionString string
-
-	// Check if running on App Engine (using unix socket)
-	if instanceConnectionName != "" {
-		connectionString = fmt.Sprintf("user=%s password=%s database=%s host=/cloudsql/%s",
-			dbUser, dbPassword, dbName, instanceConnectionName)
-	} else {
-		// Local development via Cloud SQL Proxy (tcp connection)
-		if dbPassword == "" {
-			log.Println("WARNING: DB_PASSWORD environment variable not set. Assuming unsecure local connection.")
-		}
-		// FIX: Explicitly disable SSL for local connection via the proxy
-		connectionString = fmt.Sprintf("host=127.0.0.1 port=5432 user=%s password=%s database=%s sslmode=disable",
-			dbUser, dbPassword, dbName)
-	}
-
-	var err error
-	db, err = sql.Open("postgres", connectionString)
-	if err != nil {
-		return fmt.Errorf("sql.Open failed: %w", err)
-	}
-
-	// Configure pool settings (adopted from locations.go logic)
-	db.SetMaxIdleConns(5)
-	db.SetMaxOpenConns(7)
-	db.SetConnMaxLifetime(1800)
-	
-	// Verify connection
-	if err = db.Ping(); err != nil {
-		return fmt.Errorf("db.Ping failed: %w", err)
-	}
-
-	log.Printf("Successfully connected to database: %s", dbName)
-	return nil
-}
-
 // apiSearchHandler handles the request from app.js and returns GeoJSON.
 // This replaces dropoffsHandler from locations.go and uses the correct /api/search route.
 func apiSearchHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Content-type", "application/json")
+	w.Header().Set("Content-Type", contentTypeJSON)
 	
 	// NOTE: App.js uses URL query parameters (r.URL.Query().Get), not r.FormValue
-	centerLatStr := r.URL.Query().Get("lat")
-	centerLngStr := r.URL.Query().Get("lng")
-	
-	// Radius in meters (app.js defaults to 10000m)
-	radiusMetersStr := r.URL.Query().Get("radius") 
-	if radiusMetersStr == "" {
-		radiusMetersStr = "10000"
+	q := r.URL.Query()
+	// Native clients may negotiate protobuf via Accept instead of format=pbf
+	w.Header().Add("Vary", "Accept")
+	if q.Get("format") == "" && accepts(r, contentTypeProtobuf) {
+		q.Set("format", formatPBF)
 	}
-	
-	// Basic validation for search coordinates
-	if centerLatStr == "" || centerLngStr == "" {
-		http.Error(w, `{"error": "Missing latitude or longitude parameter"}`, http.StatusBadRequest)
+	params, apiErr := parseSearchParams(q)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	// The request context flows into every downstream call, so a client
+	// disconnect cancels the DB query and any geocoding or routing call alike.
+	ctx := r.Context()
+	if apiErr := resolveCenter(ctx, &params); apiErr != nil {
+		writeAPIError(w, apiErr)
 		return
 	}
 	
-	geoJSON, err := getGeoJSONFromDatabase(centerLatStr, centerLngStr, radiusMetersStr)
+	// HEAD only reports how many features a GET would return, without building them
+	if r.Method == http.MethodHead {
+		count, capped, err := countSearchResults(ctx, params)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		setResultCount(r, count)
+		setResultCapped(r, capped)
+		w.Header().Set("X-Result-Count", strconv.Itoa(count))
+		w.Header().Set("X-Result-Capped", strconv.FormatBool(capped))
+		if count == 0 && params.EmptyNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Large results are written row by row instead of as one big string
+	if shouldStream(params) {
+		streamSearchResponse(w, r, params)
+		return
+	}
+
+	result, err := searchWithAutoExpand(ctx, params)
+	if errors.Is(err, errPoolExhausted) {
+		// Too many concurrent requests rather than a slow database
+		w.Header().Set("Retry-After", "1")
+		writeAPIError(w, &apiError{
+			Status:  http.StatusServiceUnavailable,
+			Code:    codePoolExhausted,
+			Message: "no database connection available, please retry shortly",
+		})
+		return
+	}
 	if err != nil {
-		str := fmt.Sprintf(`{"status": "error", "error": "Internal server error during query: %s"}`, err)
-		http.Error(w, str, http.StatusInternalServerError)
+		writeAPIError(w, queryError(r.Context(), err))
 		return
 	}
 	
-	// Add the "status: ok" wrapper around the GeoJSON response for the frontend JS to process
-	finalResponse := fmt.Sprintf(`{"status": "ok", "features": %s}`, geoJSON)
-	
-	fmt.Fprintf(w, finalResponse)
-}
+	setResultCount(r, result.Count)
+	setResultCapped(r, result.Capped)
+	if result.Count == 0 && params.EmptyNotFound {
+		writeAPIError(w, noResultsError(params))
+		return
+	}
 
-// getGeoJSONFromDatabase executes the PostGIS query and returns raw GeoJSON string.
-func getGeoJSONFromDatabase(centerLatStr string, centerLngStr string, radiusMetersStr string) (string, error) {
+	// Locale formatting happens here rather than in PostGIS, which has no CLDR data
+	if params.Formatted {
+		if result.Features, err = formatDistances(result.Features, params, requestLocale(r, params)); err != nil {
+			writeAPIError(w, &apiError{
+				Status:  http.StatusInternalServerError,
+				Code:    codeInternalError,
+				Message: fmt.Sprintf("Internal server error while formatting: %s", err),
+			})
+			return
+		}
+	}
 
-	// Convert string parameters to floats/ints for the query
-	centerLat, err := strconv.ParseFloat(centerLatStr, 64)
-	if err != nil {
-		return "", fmt.Errorf("invalid latitude: %w", err)
+	// Open/closed status depends on the current time, so it is computed per request in Go
+	if params.openStatus() {
+		if result.Features, err = addOpenStatus(result.Features, params, time.Now()); err != nil {
+			writeAPIError(w, &apiError{
+				Status:  http.StatusInternalServerError,
+				Code:    codeInternalError,
+				Message: fmt.Sprintf("Internal server error while computing opening hours: %s", err),
+			})
+			return
+		}
 	}
-	centerLng, err := strconv.ParseFloat(centerLngStr, 64)
-	if err != nil {
-		return "", fmt.Errorf("invalid longitude: %w", err)
+
+	if params.GroupBy != "" {
+		groups, err := groupFeatures(result.Features, params)
+		if err != nil {
+			writeAPIError(w, &apiError{
+				Status:  http.StatusInternalServerError,
+				Code:    codeInternalError,
+				Message: fmt.Sprintf("Internal server error while grouping: %s", err),
+			})
+			return
+		}
+		writeBody(w, fmt.Sprintf(`{"status": "ok", "group_by": %q, "groups": %s, "capped": %t}`, params.GroupBy, groups, result.Capped))
+		return
 	}
-	radiusMeters, err := strconv.Atoi(radiusMetersStr)
-	if err != nil {
-		return "", fmt.Errorf("invalid radius: %w", err)
+
+	if params.Format == formatPBF {
+		body, err := encodeSearchPBF(result.Features, result.Capped)
+		if err != nil {
+			writeAPIError(w, &apiError{
+				Status:  http.StatusInternalServerError,
+				Code:    codeInternalError,
+				Message: fmt.Sprintf("Internal server error while encoding: %s", err),
+			})
+			return
+		}
+		w.Header().Set("Content-Type", contentTypeProtobuf)
+		setBodyHeaders(w, body)
+		w.Write(body)
+		return
 	}
-	
-	const tableName = "austinrecycling"
-
-	// This robust query uses the ST_DWithin check and aggregates the results into a single GeoJSON array.
-	// NOTE: The table name 'austinrecycling' and geometry column 'wkb_geometry' are assumed from your GeoJSON import.
-	var queryStr = fmt.Sprintf(
-		`SELECT COALESCE(jsonb_agg(t.feature), '[]'::jsonb)
-		FROM (
-			SELECT jsonb_build_object(
-				'type', 'Feature',
-				'geometry', ST_AsGeoJSON(wkb_geometry)::jsonb,
-				'properties', to_jsonb(row) - 'ogc_fid' - 'wkb_geometry'
-			) AS feature
-			FROM (
-				SELECT *, 
-					-- Calculate distance in KM
-					ST_Distance(
-						ST_GEOGFromWKB(wkb_geometry), 
-						ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography 
-					) / 1000 AS distance_km
-				FROM %v
-				WHERE ST_DWithin(
-					ST_GEOGFromWKB(wkb_geometry), 
-					ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography, 
-					$3 -- Radius in meters
-				)
-				ORDER BY distance_km
-				LIMIT 25
-			) row
-		) t;
-		`, tableName)
-
-	// Log the query string for debugging (removed from production logs for security/verbosity)
-	// log.Println(queryStr) 
-
-	// $1 = Longitude, $2 = Latitude, $3 = Radius in Meters
-	row := db.QueryRow(queryStr, centerLng, centerLat, radiusMeters)
-	
-	var featureCollection string
-	err = row.Scan(&featureCollection)
 
-	// Handle the case where the query returns no data (e.g., empty set)
-	if err == sql.ErrNoRows {
-		return "[]", nil // Return an empty GeoJSON array
-	} else if err != nil {
-		return "", fmt.Errorf("error scanning row: %w", err)
+	if params.Format == formatTopoJSON {
+		body, err := encodeSearchTopoJSON(result.Features, params.Dataset.Key, result.Capped)
+		if err != nil {
+			writeAPIError(w, &apiError{
+				Status:  http.StatusInternalServerError,
+				Code:    codeInternalError,
+				Message: fmt.Sprintf("Internal server error while encoding: %s", err),
+			})
+			return
+		}
+		w.Header().Set("Content-Type", contentTypeTopoJSON)
+		setBodyHeaders(w, body)
+		w.Write(body)
+		return
 	}
 
-	return featureCollection, nil
-}
\ No newline at end of file
+	contentType, prefix, suffix := searchEnvelope(r, params)
+	w.Header().Set("Content-Type", contentType)
+	writeBody(w, prefix+result.Features+suffix(result))
+}
+
+// searchEnvelope returns the content type and the JSON around the features
+// array of a search response; suffix needs the result for its metadata.
+// Both the buffered and the streaming path go through it.
+func searchEnvelope(r *http.Request, params searchParams) (contentType, prefix string, suffix func(searchResult) string) {
+	// The flat format is a plain JSON array, never wrapped
+	if params.Format == formatFlat {
+		return contentTypeJSON, "", func(searchResult) string { return "" }
+	}
+
+	// GIS tools expect a standard FeatureCollection without our status wrapper
+	if !params.Envelope {
+		return contentTypeGeoJSON, `{"type": "FeatureCollection", "features": `, func(result searchResult) string {
+			if params.Metadata {
+				return `, "metadata": ` + collectionMetadata(r, params, result) + "}"
+			}
+			return "}"
+		}
+	}
+
+	// v2 clients opt in via the Accept header and get {"data": FeatureCollection, "meta": {...}}
+	if acceptsV2(r) {
+		return mediaTypeV2 + "; charset=utf-8", `{"data": {"type": "FeatureCollection", "features": `, func(result searchResult) string {
+			return `}, "meta": ` + string(newSearchMeta(params, result).encode()) + "}"
+		}
+	}
+
+	// Add the "status: ok" wrapper around the GeoJSON response for the frontend JS to process
+	// "capped" tells the UI whether more results exist beyond the limit
+	prefix, _ = SearchResponse{Status: "ok"}.envelope()
+	return contentTypeJSON, prefix, func(result searchResult) string {
+		_, suffix := SearchResponse{Status: "ok", Meta: newSearchMeta(params, result)}.envelope()
+		return suffix
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// allowMethods restricts h to the given HTTP methods. Other methods get a 405
+// with an Allow header; OPTIONS is answered as a CORS preflight when listed.
+func allowMethods(h http.HandlerFunc, methods ...string) http.HandlerFunc {
+	allow := strings.Join(methods, ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, m := range methods {
+			if r.Method != m {
+				continue
+			}
+			if m == http.MethodOptions {
+				w.Header().Set("Allow", allow)
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+				w.Header().Set("Access-Control-Allow-Methods", allow)
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, "+apiKeyHeader+", "+requestIDHeader)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			h(w, r)
+			return
+		}
+
+		w.Header().Set("Allow", allow)
+		writeAPIError(w, &apiError{
+			Status:  http.StatusMethodNotAllowed,
+			Code:    codeMethodNotAllowed,
+			Message: "method " + r.Method + " is not allowed, use " + allow,
+		})
+	}
+}
+
+// requestStats collects per-request facts that handlers report for the access log.
+type requestStats struct {
+	ResultCount       int   // features returned, -1 when not applicable
+	Capped            *bool // whether a limited search had more matches, nil when not applicable
+	UncompressedBytes int64 // body size before gzip
+}
+
+type requestStatsKey struct{}
+
+// statsFromContext returns the requestStats of the current request, or nil
+// when the request did not go through withLogging.
+func statsFromContext(ctx context.Context) *requestStats {
+	s, _ := ctx.Value(requestStatsKey{}).(*requestStats)
+	return s
+}
+
+// setResultCount records how many features a handler returned.
+func setResultCount(r *http.Request, n int) {
+	if s := statsFromContext(r.Context()); s != nil {
+		s.ResultCount = n
+	}
+}
+
+// countingWriter tracks the status code and number of body bytes written through it.
+type countingWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (cw *countingWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	n, err := cw.ResponseWriter.Write(b)
+	cw.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *countingWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// logSampleRate logs 1 in N successful requests (LOG_SAMPLE_RATE, 1 logs all).
+// Errors (status >= 400) are always logged, so sampling only thins out the
+// routine traffic that dominates log volume.
+var logSampleRate = 1
+
+// logSampleCounter counts successful requests for the 1-in-N sampling.
+var logSampleCounter atomic.Uint64
+
+// sampleRequestLog reports whether the access log line of a request with
+// the given status should be written.
+func sampleRequestLog(status int) bool {
+	if status >= http.StatusBadRequest || logSampleRate <= 1 {
+		return true
+	}
+	return logSampleCounter.Add(1)%uint64(logSampleRate) == 0
+}
+
+// withLogging emits one structured log line per request with its duration,
+// result count and both the uncompressed and on-the-wire response sizes.
+// Successful requests are sampled, see logSampleRate.
+func withLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		stats := &requestStats{ResultCount: -1, UncompressedBytes: -1}
+		cw := &countingWriter{ResponseWriter: w}
+
+		// A handler that panics, including an interrupted stream aborting the
+		// connection with http.ErrAbortHandler, is still counted and logged
+		// before the panic is passed on to net/http
+		defer func() {
+			if p := recover(); p != nil {
+				logRequest(r, cw, stats, start, true)
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(cw, r.WithContext(context.WithValue(r.Context(), requestStatsKey{}, stats)))
+		logRequest(r, cw, stats, start, false)
+	})
+}
+
+// logRequest counts the request towards usage and writes its log line.
+// Aborted requests are always logged, like errors.
+func logRequest(r *http.Request, cw *countingWriter, stats *requestStats, start time.Time, aborted bool) {
+	// Usage is counted for every request, sampled out of the log or not
+	recordUsage(r, cw.status)
+	if !aborted && !sampleRequestLog(cw.status) {
+		return
+	}
+
+	// Without gzip the handler's bytes went straight to the wire
+	if stats.UncompressedBytes < 0 {
+		stats.UncompressedBytes = cw.bytes
+	}
+	attrs := []any{
+		"request_id", requestIDFromContext(r.Context()),
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", cw.status,
+		"duration_ms", time.Since(start).Milliseconds(),
+		"bytes", stats.UncompressedBytes,
+		"bytes_compressed", cw.bytes,
+	}
+	if stats.ResultCount >= 0 {
+		attrs = append(attrs, "results", stats.ResultCount)
+	}
+	if stats.Capped != nil {
+		attrs = append(attrs, "capped", *stats.Capped)
+	}
+	if aborted {
+		attrs = append(attrs, "aborted", true)
+	} else if logSampleRate > 1 && cw.status < http.StatusBadRequest {
+		// Lets log-based metrics scale sampled lines back up to real traffic
+		attrs = append(attrs, "sample_rate", logSampleRate)
+	}
+	slog.Info("request", attrs...)
+}
+
+// gzipWriter compresses the body written through it, counting the bytes
+// written by the handler before compression.
+type gzipWriter struct {
+	http.ResponseWriter
+	gz           *gzip.Writer // nil for responses without a body (204, 304)
+	wroteHeader  bool
+	uncompressed int64
+}
+
+func (gw *gzipWriter) WriteHeader(status int) {
+	if gw.wroteHeader {
+		return
+	}
+	gw.wroteHeader = true
+	if status != http.StatusNoContent && status != http.StatusNotModified {
+		// The compressed length differs from anything the handler computed
+		gw.Header().Set("Content-Encoding", "gzip")
+		gw.Header().Del("Content-Length")
+		gw.gz = gzip.NewWriter(gw.ResponseWriter)
+	}
+	gw.ResponseWriter.WriteHeader(status)
+}
+
+func (gw *gzipWriter) Write(b []byte) (int, error) {
+	if !gw.wroteHeader {
+		gw.WriteHeader(http.StatusOK)
+	}
+	gw.uncompressed += int64(len(b))
+	if gw.gz == nil {
+		return gw.ResponseWriter.Write(b)
+	}
+	return gw.gz.Write(b)
+}
+
+// Flush pushes buffered compressed data to the client (used by streaming exports).
+func (gw *gzipWriter) Flush() {
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	http.NewResponseController(gw.ResponseWriter).Flush()
+}
+
+// withGzip compresses responses for clients that accept gzip.
+// Range requests are passed through untouched since byte ranges refer to the
+// uncompressed file.
+func withGzip(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || r.Header.Get("Range") != "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipWriter{ResponseWriter: w}
+		defer func() {
+			if gw.gz != nil {
+				gw.gz.Close()
+			}
+			if stats := statsFromContext(r.Context()); stats != nil {
+				stats.UncompressedBytes = gw.uncompressed
+			}
+		}()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// trailingSlashRedirect selects how withTrailingSlash treats "/api/search/":
+// a 308 redirect to "/api/search" when true (TRAILING_SLASH=redirect), an
+// internal rewrite otherwise. The redirect makes clients fix their URLs, the
+// rewrite saves them a round trip.
+var trailingSlashRedirect bool
+
+// apiPathPrefixes are the route prefixes withTrailingSlash normalizes. The
+// SPA routes under / are left alone, they have their own fallback.
+var apiPathPrefixes = []string{"/api/", "/tiles/", "/admin/", "/healthz/", "/readyz/", "/health/"}
+
+// withTrailingSlash strips a trailing slash from API paths, so "/api/search/"
+// reaches the same handler as "/api/search" instead of a 404 or the SPA.
+func withTrailingSlash(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if len(path) <= 1 || !strings.HasSuffix(path, "/") || !hasAPIPrefix(path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		trimmed := strings.TrimRight(path, "/")
+		if trailingSlashRedirect {
+			target := *r.URL
+			target.Path, target.RawPath = trimmed, ""
+			// 308 keeps the method and body, unlike 301 for POSTs
+			http.Redirect(w, r, target.RequestURI(), http.StatusPermanentRedirect)
+			return
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path, r2.URL.RawPath = trimmed, ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// hasAPIPrefix reports whether path belongs to an API route.
+func hasAPIPrefix(path string) bool {
+	for _, prefix := range apiPathPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxMultiDatasets caps how many datasets one /api/search/multi request may query.
+const maxMultiDatasets = 5
+
+// apiSearchMultiHandler serves /api/search/multi?datasets=recycling,parks&lat=..&lng=..:
+// the nearest features across several datasets, merged by distance under a
+// single shared limit. Every feature is tagged with its source in the
+// "dataset" property. Other /api/search parameters apply to each dataset,
+// except those that reorder or reshape results (see multiUnsupported).
+func apiSearchMultiHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", contentTypeJSON)
+
+	list, apiErr := parseMultiSearchParams(r.URL.Query())
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	ctx := r.Context()
+	if apiErr := resolveCenter(ctx, &list[0]); apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	for i := range list[1:] {
+		// Boundary and postal code centers differ per dataset
+		if apiErr := resolveCenter(ctx, &list[i+1]); apiErr != nil {
+			writeAPIError(w, apiErr)
+			return
+		}
+	}
+
+	result, err := searchMultipleDatasets(ctx, list)
+	if err != nil {
+		writeAPIError(w, queryError(r.Context(), err))
+		return
+	}
+	setResultCount(r, result.Count)
+	setResultCapped(r, result.Capped)
+
+	if list[0].Format == formatFlat {
+		writeBody(w, result.Features)
+		return
+	}
+	writeBody(w, fmt.Sprintf(`{"status": "ok", "features": %s, "capped": %t}`, result.Features, result.Capped))
+}
+
+// parseMultiSearchParams validates the `datasets` list and parses the shared
+// search parameters once per dataset.
+func parseMultiSearchParams(q url.Values) ([]searchParams, *apiError) {
+	raw := q.Get("datasets")
+	if raw == "" {
+		return nil, badRequest(codeUnknownDataset, "datasets is required, e.g. datasets=recycling,parks")
+	}
+	keys := strings.Split(raw, ",")
+	if len(keys) > maxMultiDatasets {
+		return nil, badRequest(codeInvalidParameter, "at most %d datasets can be searched at once, got %d", maxMultiDatasets, len(keys))
+	}
+
+	list := make([]searchParams, 0, len(keys))
+	seen := map[string]bool{}
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if key == "" || seen[key] {
+			return nil, badRequest(codeUnknownDataset, "datasets must list distinct, non-empty keys")
+		}
+		seen[key] = true
+
+		dq := url.Values{}
+		for k, v := range q {
+			dq[k] = v
+		}
+		dq.Del("datasets")
+		dq.Set("dataset", key)
+		p, apiErr := parseSearchParams(dq)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		if p.Format == formatPBF || p.Format == formatTopoJSON {
+			return nil, badRequest(codeInvalidFormat, "format=%s is only supported by /api/search", p.Format)
+		}
+		if p.Minimal {
+			return nil, badRequest(codeInvalidParameter, "minimal results have no distance to merge by and are not supported across datasets")
+		}
+		for _, u := range multiUnsupported {
+			if u.set(p) {
+				return nil, badRequest(codeInvalidParameter, "%s is not supported by /api/search/multi: %s", u.param, u.reason)
+			}
+		}
+		// Each dataset must return its nearest rows for the merge to keep the
+		// nearest overall, whatever order the dataset's default_order sets
+		p.Sort = sortDistance
+		list = append(list, p)
+	}
+	return list, nil
+}
+
+// multiUnsupported lists the /api/search parameters the merged search cannot
+// honor: the merge orders strictly by distance under one envelope shape.
+var multiUnsupported = []struct {
+	param  string
+	set    func(p searchParams) bool
+	reason string
+}{
+	{"sort=popularity", func(p searchParams) bool { return p.Sort == sortPopularity }, "results are merged by distance"},
+	{"boost", func(p searchParams) bool { return p.Boost }, "results are merged by distance"},
+	{"bearing", func(p searchParams) bool { return p.Bearing != nil }, "results are merged by distance"},
+	{"group_by", func(p searchParams) bool { return p.GroupBy != "" }, "results are a single merged list"},
+	{"envelope=false", func(p searchParams) bool { return !p.Envelope }, "results always use the {\"status\", \"features\"} shape"},
+	{"metadata", func(p searchParams) bool { return p.Metadata }, "there is no single dataset to describe"},
+	{"empty_as=notfound", func(p searchParams) bool { return p.EmptyNotFound }, "an empty merge is always an empty 200"},
+	{"formatted", func(p searchParams) bool { return p.Formatted }, "query each dataset on /api/search for formatted distances"},
+	{"auto_expand", func(p searchParams) bool { return p.AutoExpand }, "the datasets would be searched with different radii; pass a wider radius"},
+}
+
+// searchMultipleDatasets runs the search on every dataset, each limited to
+// the shared limit, then merges the features by distance and keeps the nearest.
+func searchMultipleDatasets(ctx context.Context, list []searchParams) (searchResult, error) {
+	type ranked struct {
+		feature  map[string]json.RawMessage
+		props    map[string]json.RawMessage // the feature itself for flat results
+		distance float64
+	}
+	var (
+		merged []ranked
+		capped bool
+		now    = time.Now()
+	)
+	for _, p := range list {
+		result, err := getGeoJSONFromDatabase(ctx, p)
+		if err != nil {
+			return searchResult{}, fmt.Errorf("dataset %q: %w", p.Dataset.Key, err)
+		}
+		capped = capped || result.Capped
+		if p.openStatus() {
+			if result.Features, err = addOpenStatus(result.Features, p, now); err != nil {
+				return searchResult{}, fmt.Errorf("dataset %q: computing opening hours: %w", p.Dataset.Key, err)
+			}
+		}
+
+		var features []map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(result.Features), &features); err != nil {
+			return searchResult{}, fmt.Errorf("dataset %q: decoding features: %w", p.Dataset.Key, err)
+		}
+		tag, _ := json.Marshal(p.Dataset.Key)
+		for _, f := range features {
+			// Flat results carry the properties at the top level
+			props := f
+			if p.Format != formatFlat {
+				if err := json.Unmarshal(f["properties"], &props); err != nil {
+					return searchResult{}, fmt.Errorf("dataset %q: decoding properties: %w", p.Dataset.Key, err)
+				}
+			}
+			var distance float64
+			json.Unmarshal(props[distanceUnits[p.Unit].column], &distance)
+			props["dataset"] = tag
+			merged = append(merged, ranked{feature: f, props: props, distance: distance})
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].distance < merged[j].distance })
+	if limit := list[0].Limit; len(merged) > limit {
+		merged, capped = merged[:limit], true
+	}
+
+	// Each dataset ranked its own rows; the merged list is ranked anew
+	flat := list[0].Format == formatFlat
+	features := make([]map[string]json.RawMessage, len(merged))
+	for i, m := range merged {
+		m.props["rank"], _ = json.Marshal(i + 1)
+		if !flat {
+			m.feature["properties"], _ = json.Marshal(m.props)
+		}
+		features[i] = m.feature
+	}
+	out, err := json.Marshal(features)
+	if err != nil {
+		return searchResult{}, fmt.Errorf("encoding features: %w", err)
+	}
+	return searchResult{Features: string(out), Count: len(features), Capped: capped}, nil
+}
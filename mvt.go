@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// contentTypeMVT is the media type of Mapbox Vector Tiles.
+const contentTypeMVT = "application/vnd.mapbox-vector-tile"
+
+// mvtExtent and mvtBuffer are the tile grid size and edge buffer passed to ST_AsMVTGeom.
+const (
+	mvtExtent = 4096
+	mvtBuffer = 64
+)
+
+// tileCacheDir is where generated vector tiles are cached (TILE_CACHE_DIR),
+// empty to disable caching. Tiles are stored as
+// <dir>/<dataset>/<descriptor version>/<z>/<x>/<y>.mvt, so a reload that
+// changes a dataset never serves a stale tile, while unchanged datasets keep
+// their cache across reloads and restarts.
+var tileCacheDir string
+
+// apiTileMVTHandler serves /tiles/{z}/{x}/{y}.mvt: a vector tile with one
+// layer named after the dataset, served from the disk cache when possible.
+func apiTileMVTHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	tile, _, apiErr := parseTilePath(r, ".mvt")
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	ds, apiErr := lookupDataset(r.URL.Query().Get("dataset"))
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	cachePath := tileCachePath(ds, tile)
+	if cachePath != "" {
+		if data, err := os.ReadFile(cachePath); err == nil {
+			writeMVT(w, data, "HIT")
+			return
+		}
+	}
+
+	data, err := getTileMVTFromDatabase(r.Context(), ds, tile)
+	if err != nil {
+		writeAPIError(w, queryError(r.Context(), err))
+		return
+	}
+	if cachePath != "" {
+		// A failed cache write only costs a regeneration next time
+		if err := writeFileAtomic(cachePath, data); err != nil {
+			log.Printf("WARNING: caching tile %s: %v", cachePath, err)
+		}
+	}
+	writeMVT(w, data, "MISS")
+}
+
+func writeMVT(w http.ResponseWriter, data []byte, cacheStatus string) {
+	w.Header().Set("Content-Type", contentTypeMVT)
+	setBodyHeaders(w, data)
+	w.Header().Set("X-Cache", cacheStatus)
+	w.Write(data)
+}
+
+// getTileMVTFromDatabase encodes the features of a tile with ST_AsMVT.
+// Attributes are the id, category and filter columns of the dataset; the
+// full property set would bloat every tile.
+func getTileMVTFromDatabase(ctx context.Context, ds *dataset, t tileID) ([]byte, error) {
+	attrs := []string{ds.idCol()}
+	if ds.CategoryColumn != "" {
+		attrs = append(attrs, pq.QuoteIdentifier(ds.CategoryColumn))
+	}
+	for _, col := range ds.Filters {
+		if col != ds.CategoryColumn {
+			attrs = append(attrs, pq.QuoteIdentifier(col))
+		}
+	}
+
+	var queryStr = fmt.Sprintf(
+		`SELECT ST_AsMVT(mvt, %[1]s, %[2]d, 'mvt_geom')
+		FROM (
+			SELECT %[3]s, ST_AsMVTGeom(ST_Transform(%[4]s, 3857), ST_TileEnvelope($1, $2, $3), %[2]d, %[5]d, true) AS mvt_geom
+			FROM %[6]s
+			-- && against the tile in the native SRID keeps the spatial index usable
+			WHERE %[4]s && ST_Transform(ST_TileEnvelope($1, $2, $3), %[7]d)
+			LIMIT %[8]d
+		) mvt
+		WHERE mvt_geom IS NOT NULL;
+		`, pq.QuoteLiteral(ds.Key), mvtExtent, strings.Join(attrs, ", "), ds.geomCol(), mvtBuffer,
+		ds.quotedTable(), ds.SRID, maxTileFeatures)
+
+	var data []byte
+	if err := readDB.QueryRowContext(ctx, queryStr, t.Z, t.X, t.Y).Scan(&data); err != nil {
+		return nil, fmt.Errorf("error scanning tile: %w", err)
+	}
+	return data, nil
+}
+
+// tileCachePath returns the cache file of a tile, or "" when caching is off.
+func tileCachePath(ds *dataset, t tileID) string {
+	if tileCacheDir == "" || ds.version == "" {
+		return ""
+	}
+	return filepath.Join(tileCacheDir, ds.Key, ds.version,
+		strconv.Itoa(t.Z), strconv.Itoa(t.X), strconv.Itoa(t.Y)+".mvt")
+}
+
+// writeFileAtomic writes data through a temporary file and a rename, so
+// concurrent readers never see a partially written tile.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tile-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// pruneTileCache deletes cached tiles of removed datasets and of previous
+// descriptor versions.
+func pruneTileCache() {
+	if tileCacheDir == "" {
+		return
+	}
+	reg := currentDatasets()
+	datasetDirs, err := os.ReadDir(tileCacheDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	} else if err != nil {
+		log.Printf("WARNING: pruning tile cache: %v", err)
+		return
+	}
+	for _, d := range datasetDirs {
+		if !d.IsDir() {
+			continue
+		}
+		dir := filepath.Join(tileCacheDir, d.Name())
+		ds, ok := reg.byKey[d.Name()]
+		if !ok {
+			removeCacheDir(dir)
+			continue
+		}
+		versions, err := os.ReadDir(dir)
+		if err != nil {
+			log.Printf("WARNING: pruning tile cache: %v", err)
+			continue
+		}
+		for _, v := range versions {
+			if v.Name() != ds.version {
+				removeCacheDir(filepath.Join(dir, v.Name()))
+			}
+		}
+	}
+}
+
+func removeCacheDir(dir string) {
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("WARNING: pruning tile cache: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+// apiNearestPerCategoryHandler serves /api/nearest-per-category: the single
+// nearest feature of every category within the search radius, so a dashboard
+// gets "nearest glass, nearest plastic, ..." without one search per category.
+// It accepts the /api/search parameters; features are ordered by category.
+func apiNearestPerCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", contentTypeJSON)
+
+	params, apiErr := parseSearchParams(r.URL.Query())
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	if params.Dataset.CategoryColumn == "" {
+		writeAPIError(w, badRequest(codeInvalidCategory, "dataset %q has no category column", params.Dataset.Key))
+		return
+	}
+	ctx := r.Context()
+	if apiErr := resolveCenter(ctx, &params); apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	result, err := getNearestPerCategoryFromDatabase(ctx, params)
+	if err != nil {
+		writeAPIError(w, queryError(r.Context(), err))
+		return
+	}
+	setResultCount(r, result.Count)
+
+	writeBody(w, fmt.Sprintf(`{"status": "ok", "count": %d, "features": %s}`, result.Count, result.Features))
+}
+
+// getNearestPerCategoryFromDatabase picks the nearest row of each category with
+// DISTINCT ON, which PostgreSQL resolves in a single sort of the filtered rows.
+// Rows without a category are ignored.
+func getNearestPerCategoryFromDatabase(ctx context.Context, p searchParams) (searchResult, error) {
+	du, ok := distanceUnits[p.Unit]
+	if !ok {
+		return searchResult{}, fmt.Errorf("unsupported unit: %q", p.Unit)
+	}
+	ds := p.Dataset
+	category := pq.QuoteIdentifier(ds.CategoryColumn)
+	f := newSearchFilter(p)
+	where := f.where() + "\n\t\t\t\tAND " + category + " IS NOT NULL"
+	nearestPoint := ""
+	if np := f.nearestPoint(); np != "" {
+		nearestPoint = " || " + np
+	}
+
+	var queryStr = fmt.Sprintf(
+		`SELECT COALESCE(jsonb_agg(t.feature ORDER BY t.category), '[]'::jsonb), count(*)
+		FROM (
+			SELECT DISTINCT ON (row.%[1]s) row.%[1]s AS category, jsonb_build_object(
+				'type', 'Feature',
+				'geometry', %[2]s,
+				'properties', (%[3]s) || jsonb_build_object(%[4]s, round(row.%[5]s::numeric, %[6]d))%[11]s
+			) AS feature
+			FROM (
+				SELECT *, %[7]s / %[8]v AS %[5]s
+				FROM %[9]s
+				WHERE %[10]s
+			) row
+			ORDER BY row.%[1]s, row.%[5]s
+		) t;
+		`, category, geometryFormats[p.GeometryFormat](ds.geom()), ds.propertiesExpr("row"), pq.QuoteLiteral(du.column),
+		du.column, distancePrecision, f.distance(), du.divisor, ds.quotedTable(), where, nearestPoint)
+
+	var result searchResult
+	logQuery(ctx, "nearest_per_category", queryStr, f.args)
+	if err := readDB.QueryRowContext(ctx, queryStr, f.args...).Scan(&result.Features, &result.Count); err != nil {
+		return searchResult{}, fmt.Errorf("error scanning row: %w", err)
+	}
+	return result, nil
+}
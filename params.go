@@ -0,0 +1,686 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// defaultRadiusMeters matches the radius app.js sends when none is provided.
+const defaultRadiusMeters = 10000
+
+// Result cap for a single search. The default matches the historic LIMIT 25;
+// clients may lower it or raise it up to maxSearchLimit.
+const (
+	defaultSearchLimit = 25
+	maxSearchLimit     = 200
+)
+
+// Output formats accepted by the `format` parameter.
+const (
+	formatGeoJSON  = "geojson"
+	formatFlat     = "flat"     // plain array of property objects with lat/lng
+	formatPBF      = "pbf"      // protobuf FeatureCollection (proto/locator.proto) for native clients
+	formatTopoJSON = "topojson" // Topology with shared arcs, for adjacent polygon layers (see topojson.go)
+)
+
+// Distance computations accepted by `distance_mode`.
+const (
+	distanceModeGeography = "geography" // on the WGS84 spheroid, exact
+	distanceModePlanar    = "planar"    // in the center's UTM zone, faster, ~0.1% off
+)
+
+// Orderings accepted by the `sort` parameter. Distance is always the final tiebreaker.
+const (
+	sortDistance   = "distance"
+	sortPopularity = "popularity" // dataset popularity_column, highest first
+)
+
+// mediaTypeV2 selects the v2 response shape. v1 (the status wrapper app.js
+// depends on) stays the default for any other Accept header.
+const mediaTypeV2 = "application/vnd.locator.v2+json"
+
+// acceptsV2 reports whether the client asked for the v2 response shape.
+func acceptsV2(r *http.Request) bool {
+	return accepts(r, mediaTypeV2)
+}
+
+// accepts reports whether the Accept header of r lists want.
+func accepts(r *http.Request, want string) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaType := range strings.Split(accept, ",") {
+			if strings.TrimSpace(strings.SplitN(mediaType, ";", 2)[0]) == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// searchParams holds the validated query parameters of a radius search.
+type searchParams struct {
+	Dataset            *dataset
+	Lat                float64
+	Lng                float64
+	Zip                string // postal code whose polygon supplies Lat/Lng, resolved by the handler
+	RadiusMeters       int    // 0 disables the radius constraint (boundary searches only)
+	MinRadiusMeters    int    // features closer than this are excluded (annulus search), 0 for none
+	AutoExpand         bool   // widen the radius when nothing is found
+	Unit               string
+	DistanceMode       string // distanceModeGeography (default) or distanceModePlanar
+	AllUnits           bool   // include the distance in every unit, not just Unit
+	Limit              int
+	Categories         []string      // matched with OR semantics (category = ANY(...))
+	Has                []string      // columns that must be non-NULL and non-empty
+	Where              []whereClause // comparisons from the `where` parameter, ANDed
+	ETAMode            string        // walk or drive, empty for no eta_min property
+	ETASpeed           float64       // km/h used for eta_min
+	Boost              bool          // order featured rows first, then by distance
+	Sort               string        // sortDistance or sortPopularity, empty for the dataset's default_order
+	Bearing            *float64      // degrees clockwise from north to favor when ordering, nil for none
+	Elongation         float64       // how much closer features along Bearing rank, see bearingWeight
+	PerCategoryLimit   int           // max rows per category, 0 for no cap
+	GroupBy            string        // column to nest results by (see groupFeatures), empty for a flat list
+	DedupeBy           string        // business key column, only the nearest row per key is kept
+	Minimal            bool          // omit properties, returning only id + geometry
+	FeatureBBox        bool          // add properties.bbox = [minLng, minLat, maxLng, maxLat]
+	FeatureGeomType    bool          // add properties.geometry_type (Point, MultiPolygon, ...) for mixed datasets
+	Format             string
+	GeometryFormat     string       // key of geometryFormats, geojson by default
+	Formatted          bool         // add locale-formatted distance strings next to the raw values
+	Lang               string       // language of the dataset's localized fields, empty for its default
+	Locale             language.Tag // explicit `locale`, language.Und to use Accept-Language
+	WithinBoundary     string       // boundary id from the dataset's boundaries table
+	BBox               *tileBounds  // viewport filter (bbox=minLng,minLat,maxLng,maxLat), nil for none
+	ExcludePolygon     string       // validated GeoJSON MultiPolygon whose features are left out
+	CenterFromBoundary bool         // no lat/lng given: measure distance from the boundary
+	Envelope           bool         // wrap features in {"status": "ok", ...}; false returns a bare FeatureCollection
+	Metadata           bool         // add a "metadata" foreign member to the bare FeatureCollection
+	IncludeGeometry    bool         // false drops the geometry member for list-only views (not valid GeoJSON)
+	EmptyNotFound      bool         // empty_as=notfound: no matching features is a 404 no_results instead of an empty 200
+}
+
+// exclusiveParams lists parameters that must not be combined, with the reason
+// shown to the client. A side is either a parameter name, which conflicts
+// whenever it is present (even with a no-op value), or name=value, which only
+// conflicts for that value.
+var exclusiveParams = []struct {
+	a, b   string
+	reason string
+}{
+	{"zip", "lat", "zip is resolved to lat/lng, pass either a zip or coordinates"},
+	{"zip", "lng", "zip is resolved to lat/lng, pass either a zip or coordinates"},
+	{"radius", "max_distance", "both set the search radius, radius in meters and max_distance in the requested unit"},
+	{"minimal", "format=flat", "flat results are property objects, minimal only strips GeoJSON properties"},
+	{"properties", "format=flat", "flat results are property objects, properties=false only strips GeoJSON properties"},
+	{"envelope", "format=flat", "flat results are a bare array, envelope only applies to GeoJSON"},
+	{"envelope", "format=pbf", "protobuf results have a fixed message shape, envelope only applies to GeoJSON"},
+	{"group_by", "format=pbf", "protobuf results have a fixed message shape, group_by returns nested JSON"},
+	{"envelope", "format=topojson", "TopoJSON results are a Topology object, envelope only applies to GeoJSON"},
+	{"group_by", "format=topojson", "TopoJSON results are a Topology object, group_by returns nested JSON"},
+	{"group_by", "envelope", "grouped results always use the {\"status\", \"groups\"} shape"},
+}
+
+// checkExclusiveParams rejects requests combining parameters from exclusiveParams.
+func checkExclusiveParams(q url.Values) *apiError {
+	given := func(spec string) bool {
+		name, value, hasValue := strings.Cut(spec, "=")
+		return q.Has(name) && (!hasValue || q.Get(name) == value)
+	}
+	for _, rule := range exclusiveParams {
+		if given(rule.a) && given(rule.b) {
+			return badRequest(codeConflictingParameters, "%s and %s cannot be combined: %s", rule.a, rule.b, rule.reason)
+		}
+	}
+	return nil
+}
+
+// parseSearchParams validates the /api/search query string.
+// Every failure is returned as a 400 apiError with a field-specific code.
+func parseSearchParams(q url.Values) (searchParams, *apiError) {
+	if apiErr := checkExclusiveParams(q); apiErr != nil {
+		return searchParams{}, apiErr
+	}
+	p := searchParams{RadiusMeters: defaultRadiusMeters, Limit: defaultSearchLimit, Format: formatGeoJSON,
+		GeometryFormat: geometryFormatGeoJSON, IncludeGeometry: true}
+	var apiErr *apiError
+
+	if p.Dataset, apiErr = lookupDataset(q.Get("dataset")); apiErr != nil {
+		return p, apiErr
+	}
+
+	// within_boundary=<id> restricts results to a named polygon (e.g. a council district)
+	if p.WithinBoundary = q.Get("within_boundary"); p.WithinBoundary != "" && p.Dataset.Boundaries == nil {
+		return p, badRequest(codeBoundaryUnsupported, "dataset %q has no boundaries configured", p.Dataset.Key)
+	}
+
+	// bbox=minLng,minLat,maxLng,maxLat limits results to the map viewport
+	// ("search here"); they are still ordered by distance from the center
+	if raw := q.Get("bbox"); raw != "" {
+		if p.BBox, apiErr = parseBBox(raw); apiErr != nil {
+			return p, apiErr
+		}
+	}
+
+	// zip=<code> centers the search inside that postal code's polygon
+	if p.Zip = strings.TrimSpace(q.Get("zip")); p.Zip != "" && p.Dataset.PostalCodes == nil {
+		return p, badRequest(codeZipUnsupported, "dataset %q has no postal_codes configured", p.Dataset.Key)
+	}
+
+	// Either explicit coordinates, a postal code, or (for boundary and bbox
+	// searches) the area itself as the distance reference point
+	noCoordinates := q.Get("lat") == "" && q.Get("lng") == ""
+	switch {
+	case p.Zip != "":
+		// Resolved by resolveCenter; exclusiveParams already rejected lat/lng
+	case noCoordinates && p.WithinBoundary != "":
+		p.CenterFromBoundary = true
+	case noCoordinates && p.BBox != nil:
+		p.Lat, p.Lng = p.BBox.center()
+	default:
+		if p.Lat, p.Lng, apiErr = parseCoordinates(q); apiErr != nil {
+			return p, apiErr
+		}
+	}
+
+	// A boundary or bbox search is bounded by its area, so the radius only applies when explicit
+	if (p.WithinBoundary != "" || p.BBox != nil) && q.Get("radius") == "" {
+		p.RadiusMeters = 0
+	}
+
+	var err error
+	// Radius in meters (app.js defaults to 10000m)
+	if radiusStr := q.Get("radius"); radiusStr != "" {
+		if p.RadiusMeters, err = strconv.Atoi(radiusStr); err != nil {
+			return p, badRequest(codeInvalidRadius, "invalid radius: %q is not an integer number of meters", radiusStr)
+		}
+		if p.RadiusMeters <= 0 {
+			return p, badRequest(codeOutOfRangeRadius, "radius must be greater than 0 meters")
+		}
+	}
+
+	// Distance unit for the output property (km by default, mi for imperial clients)
+	if p.Unit, apiErr = parseUnit(q); apiErr != nil {
+		return p, apiErr
+	}
+
+	// max_distance is the radius in the requested unit, so imperial clients never convert
+	if raw := q.Get("max_distance"); raw != "" {
+		maxDistance, err := strconv.ParseFloat(raw, 64)
+		if err != nil || !isFinite(maxDistance) || maxDistance <= 0 {
+			return p, badRequest(codeInvalidRadius, "max_distance must be a positive number of %s, got %q", p.Unit, raw)
+		}
+		// Rounded up to whole meters: at most a meter of slack, never a missing feature
+		meters := math.Ceil(maxDistance * distanceUnits[p.Unit].divisor)
+		if meters > math.MaxInt32 {
+			return p, badRequest(codeOutOfRangeRadius, "max_distance %v %s is too large", maxDistance, p.Unit)
+		}
+		p.RadiusMeters = int(meters)
+	}
+
+	// min_radius=N turns the search into a ring: only features at least N meters away
+	if raw := q.Get("min_radius"); raw != "" {
+		if p.MinRadiusMeters, err = strconv.Atoi(raw); err != nil {
+			return p, badRequest(codeInvalidRadius, "invalid min_radius: %q is not an integer number of meters", raw)
+		}
+		if p.MinRadiusMeters <= 0 {
+			return p, badRequest(codeOutOfRangeRadius, "min_radius must be greater than 0 meters")
+		}
+		// Checked against the final radius, whether radius, max_distance or the default set it
+		if p.RadiusMeters > 0 && p.MinRadiusMeters >= p.RadiusMeters {
+			if maxDistance := q.Get("max_distance"); maxDistance != "" {
+				return p, badRequest(codeOutOfRangeRadius, "min_radius (%d m) must be smaller than max_distance (%s %s)", p.MinRadiusMeters, maxDistance, p.Unit)
+			}
+			return p, badRequest(codeOutOfRangeRadius, "min_radius (%d) must be smaller than radius (%d)", p.MinRadiusMeters, p.RadiusMeters)
+		}
+	}
+
+	// auto_expand=true retries empty searches with a larger radius (see searchWithAutoExpand)
+	if p.AutoExpand, apiErr = parseBoolParam(q, "auto_expand", false); apiErr != nil {
+		return p, apiErr
+	}
+
+	// distance_mode=planar trades a little accuracy for speed (see searchFilter.distance)
+	switch mode := q.Get("distance_mode"); mode {
+	case "", distanceModeGeography:
+		p.DistanceMode = distanceModeGeography
+	case distanceModePlanar:
+		p.DistanceMode = mode
+	default:
+		return p, badRequest(codeInvalidParameter, "unsupported distance_mode %q, expected geography or planar", mode)
+	}
+
+	// all_units=true adds distance_km and distance_mi whatever the display unit
+	if p.AllUnits, apiErr = parseBoolParam(q, "all_units", false); apiErr != nil {
+		return p, apiErr
+	}
+
+	if limitStr := q.Get("limit"); limitStr != "" {
+		if p.Limit, err = strconv.Atoi(limitStr); err != nil || p.Limit < 1 || p.Limit > maxSearchLimit {
+			return p, badRequest(codeInvalidLimit, "limit must be an integer between 1 and %d, got %q", maxSearchLimit, limitStr)
+		}
+	}
+
+	// category=glass,plastic matches any of the listed values
+	if raw, ok := q["category"]; ok {
+		if p.Dataset.CategoryColumn == "" {
+			return p, badRequest(codeInvalidCategory, "dataset %q does not support category filters", p.Dataset.Key)
+		}
+		for _, c := range strings.Split(strings.Join(raw, ","), ",") {
+			c = strings.TrimSpace(c)
+			if c == "" {
+				return p, badRequest(codeInvalidCategory, "category list must not contain empty values")
+			}
+			p.Categories = append(p.Categories, c)
+		}
+	}
+
+	// has=website,phone keeps only features where every listed column is populated
+	if raw := q.Get("has"); raw != "" {
+		for _, col := range strings.Split(raw, ",") {
+			col = strings.TrimSpace(col)
+			if !p.Dataset.filterable(col) {
+				return p, badRequest(codeInvalidFilter, "column %q is not filterable on dataset %q", col, p.Dataset.Key)
+			}
+			p.Has = append(p.Has, col)
+		}
+	}
+
+	// exclude_polygon=<GeoJSON Polygon> drops features inside an already covered area
+	if raw := q.Get("exclude_polygon"); raw != "" {
+		if p.ExcludePolygon, apiErr = parsePolygon("exclude_polygon", []byte(raw)); apiErr != nil {
+			return p, apiErr
+		}
+	}
+
+	// where=capacity>=10,material=glass for comparisons beyond equality
+	if raw := q.Get("where"); raw != "" {
+		if p.Where, apiErr = parseWhere(raw, p.Dataset); apiErr != nil {
+			return p, apiErr
+		}
+	}
+
+	// eta=walk|drive adds an eta_min estimate; speed=<km/h> overrides the mode's default
+	if mode := q.Get("eta"); mode != "" {
+		speed, ok := etaSpeeds[mode]
+		if !ok {
+			return p, badRequest(codeInvalidParameter, "unsupported eta mode %q, expected walk or drive", mode)
+		}
+		if raw := q.Get("speed"); raw != "" {
+			if speed, err = strconv.ParseFloat(raw, 64); err != nil || !isFinite(speed) || speed <= 0 || speed > maxETASpeed {
+				return p, badRequest(codeInvalidParameter, "speed must be a number of km/h in (0, %d], got %q", maxETASpeed, raw)
+			}
+		}
+		p.ETAMode, p.ETASpeed = mode, speed
+	}
+
+	// per_category_limit=N caps each category at its N nearest rows
+	if raw := q.Get("per_category_limit"); raw != "" {
+		if p.Dataset.CategoryColumn == "" {
+			return p, badRequest(codeInvalidCategory, "dataset %q does not support category limits", p.Dataset.Key)
+		}
+		if p.PerCategoryLimit, err = strconv.Atoi(raw); err != nil || p.PerCategoryLimit < 1 {
+			return p, badRequest(codeInvalidParameter, "per_category_limit must be a positive integer, got %q", raw)
+		}
+	}
+
+	// boost=true floats featured/sponsored rows above closer non-featured ones
+	if p.Boost, apiErr = parseBoolParam(q, "boost", false); apiErr != nil {
+		return p, apiErr
+	}
+	if p.Boost && p.Dataset.FeaturedColumn == "" {
+		return p, badRequest(codeInvalidParameter, "dataset %q has no featured column to boost by", p.Dataset.Key)
+	}
+
+	// sort=popularity for "most popular nearby" views
+	switch sort := q.Get("sort"); sort {
+	case "":
+		// The dataset's default_order, distance first when it has none
+	case sortDistance:
+		p.Sort = sort
+	case sortPopularity:
+		if p.Dataset.PopularityColumn == "" {
+			return p, badRequest(codeInvalidParameter, "dataset %q has no popularity column to sort by", p.Dataset.Key)
+		}
+		p.Sort = sort
+	default:
+		return p, badRequest(codeInvalidParameter, "unsupported sort %q, expected distance or popularity", sort)
+	}
+
+	// bearing=<deg> (with an optional elongation) ranks features along a direction first
+	if raw := q.Get("bearing"); raw != "" {
+		bearing, err := strconv.ParseFloat(raw, 64)
+		if err != nil || !isFinite(bearing) || bearing < 0 || bearing >= 360 {
+			return p, badRequest(codeInvalidParameter, "bearing must be a number of degrees in [0, 360), got %q", raw)
+		}
+		p.Bearing, p.Elongation = &bearing, defaultElongation
+	}
+	if raw := q.Get("elongation"); raw != "" {
+		if p.Bearing == nil {
+			return p, badRequest(codeInvalidParameter, "elongation needs a bearing")
+		}
+		var err error
+		if p.Elongation, err = strconv.ParseFloat(raw, 64); err != nil || !isFinite(p.Elongation) || p.Elongation < 1 || p.Elongation > maxElongation {
+			return p, badRequest(codeInvalidParameter, "elongation must be a number between 1 and %d, got %q", maxElongation, raw)
+		}
+	}
+
+	// dedupe_by=<column> drops farther rows sharing a business key (chain duplicates)
+	if col := q.Get("dedupe_by"); col != "" {
+		if col != p.Dataset.CategoryColumn && !p.Dataset.filterable(col) {
+			return p, badRequest(codeInvalidParameter, "cannot dedupe by %q on dataset %q, use the category column or a filterable column", col, p.Dataset.Key)
+		}
+		p.DedupeBy = col
+	}
+
+	// group_by=<column> nests the results by that column for categorized list views
+	if col := q.Get("group_by"); col != "" {
+		if col != p.Dataset.CategoryColumn && !p.Dataset.filterable(col) {
+			return p, badRequest(codeInvalidParameter, "cannot group by %q on dataset %q, use the category column or a filterable column", col, p.Dataset.Key)
+		}
+		p.GroupBy = col
+	}
+
+	// properties=false (or its alias minimal=true) for pin-only map views
+	withProperties, apiErr := parseBoolParam(q, "properties", true)
+	if apiErr != nil {
+		return p, apiErr
+	}
+	minimal, apiErr := parseBoolParam(q, "minimal", false)
+	if apiErr != nil {
+		return p, apiErr
+	}
+	p.Minimal = minimal || !withProperties
+
+	// feature_bbox=true adds properties.bbox so clients can cull complex geometries cheaply
+	if p.FeatureBBox, apiErr = parseBoolParam(q, "feature_bbox", false); apiErr != nil {
+		return p, apiErr
+	}
+	if p.GroupBy != "" && p.Minimal {
+		return p, badRequest(codeConflictingParameters, "group_by reads the grouping column from the properties, it cannot be combined with properties=false or minimal=true")
+	}
+	if p.FeatureBBox && p.Minimal {
+		return p, badRequest(codeConflictingParameters, "feature_bbox adds a property, it cannot be combined with properties=false or minimal=true")
+	}
+
+	// geometry_type=true tells clients of mixed datasets each feature's shape without parsing it
+	if p.FeatureGeomType, apiErr = parseBoolParam(q, "geometry_type", false); apiErr != nil {
+		return p, apiErr
+	}
+	if p.FeatureGeomType && p.Minimal {
+		return p, badRequest(codeConflictingParameters, "geometry_type adds a property, it cannot be combined with properties=false or minimal=true")
+	}
+
+	switch format := q.Get("format"); format {
+	case "":
+	case formatGeoJSON, formatFlat, formatPBF, formatTopoJSON:
+		p.Format = format
+	default:
+		return p, badRequest(codeInvalidFormat, "unsupported format %q, expected geojson, flat, pbf or topojson", format)
+	}
+
+	// geometry_format=wkt|ewkb for GIS tooling that does not speak GeoJSON geometries
+	if gf := q.Get("geometry_format"); gf != "" {
+		if _, ok := geometryFormats[gf]; !ok {
+			return p, badRequest(codeInvalidFormat, "unsupported geometry_format %q, expected geojson, wkt or ewkb", gf)
+		}
+		if (p.Format == formatFlat || p.Format == formatPBF) && gf != geometryFormatGeoJSON {
+			return p, badRequest(codeInvalidFormat, "geometry_format %q cannot be combined with format=%s, which has no geometry", gf, p.Format)
+		}
+		if p.Format == formatTopoJSON && gf != geometryFormatGeoJSON {
+			return p, badRequest(codeInvalidFormat, "geometry_format %q cannot be combined with format=topojson, which builds its arcs from GeoJSON geometries", gf)
+		}
+		p.GeometryFormat = gf
+	}
+
+	// lang=es fills canonical properties (e.g. name) from the dataset's localized columns
+	if lang := strings.ToLower(q.Get("lang")); lang != "" {
+		if !p.Dataset.hasLanguage(lang) {
+			return p, badRequest(codeInvalidParameter, "lang %q is not available for dataset %q", lang, p.Dataset.Key)
+		}
+		p.Lang = lang
+	}
+
+	// formatted=true adds display strings like "1,3 km" for thin clients
+	if p.Formatted, apiErr = parseBoolParam(q, "formatted", false); apiErr != nil {
+		return p, apiErr
+	}
+	if raw := q.Get("locale"); raw != "" {
+		tag, err := language.Parse(raw)
+		if err != nil {
+			return p, badRequest(codeInvalidParameter, "invalid locale %q, expected a BCP 47 tag like en-US or de", raw)
+		}
+		p.Locale = tag
+	}
+
+	// envelope=false for standard GeoJSON consumers; app.js relies on the default wrapper
+	if p.Envelope, apiErr = parseBoolParam(q, "envelope", true); apiErr != nil {
+		return p, apiErr
+	}
+
+	// metadata=true makes the bare FeatureCollection self-describing (see collectionMetadata)
+	if p.Metadata, apiErr = parseBoolParam(q, "metadata", false); apiErr != nil {
+		return p, apiErr
+	}
+	if p.Metadata && (p.Envelope || p.Format != formatGeoJSON || p.GroupBy != "") {
+		return p, badRequest(codeConflictingParameters, "metadata is a GeoJSON foreign member, it needs envelope=false and the geojson format")
+	}
+
+	// include_geometry=false keeps id + properties for text lists; without
+	// geometries the features are no longer valid GeoJSON, so the bare
+	// FeatureCollection (and formats that have no geometry anyway) refuse it
+	if p.IncludeGeometry, apiErr = parseBoolParam(q, "include_geometry", true); apiErr != nil {
+		return p, apiErr
+	}
+	if !p.IncludeGeometry {
+		switch {
+		case p.Format != formatGeoJSON:
+			return p, badRequest(codeConflictingParameters, "include_geometry only applies to the geojson format, format=%s has no geometry member", p.Format)
+		case !p.Envelope:
+			return p, badRequest(codeConflictingParameters, "include_geometry=false cannot be combined with envelope=false, a FeatureCollection needs geometries")
+		case p.Minimal:
+			return p, badRequest(codeConflictingParameters, "include_geometry=false cannot be combined with properties=false or minimal=true, nothing but the id would remain")
+		case q.Get("geometry_format") != "":
+			return p, badRequest(codeConflictingParameters, "geometry_format has no effect with include_geometry=false")
+		}
+	}
+
+	// empty_as=notfound for clients that treat "nothing here" as an error;
+	// the default keeps the 200 with an empty features array
+	switch emptyAs := q.Get("empty_as"); emptyAs {
+	case "", "ok":
+	case "notfound":
+		p.EmptyNotFound = true
+	default:
+		return p, badRequest(codeInvalidParameter, "empty_as must be ok or notfound, got %q", emptyAs)
+	}
+
+	// One budget over radius, limit, filters and output options, see cost.go
+	if apiErr := checkQueryCost(p); apiErr != nil {
+		return p, apiErr
+	}
+
+	return p, nil
+}
+
+// openStatus reports whether is_open_now is added to the results: the
+// dataset has opening hours and the features carry properties.
+func (p searchParams) openStatus() bool {
+	return p.Dataset.HoursColumn != "" && !p.Minimal
+}
+
+// noResultsError is the 404 of an empty search with empty_as=notfound.
+func noResultsError(p searchParams) *apiError {
+	return &apiError{Status: http.StatusNotFound, Code: codeNoResults, Message: "no " + p.Dataset.Key + " features match the search"}
+}
+
+// resolveCenter fills in Lat/Lng for searches given by postal code or boundary.
+func resolveCenter(ctx context.Context, p *searchParams) (apiErr *apiError) {
+	switch {
+	case p.Zip != "":
+		p.Lat, p.Lng, apiErr = postalCodeCenter(ctx, p.Dataset.PostalCodes, p.Zip)
+	case p.CenterFromBoundary:
+		p.Lat, p.Lng, apiErr = boundaryCenter(ctx, p.Dataset.Boundaries, p.WithinBoundary)
+	}
+	return apiErr
+}
+
+// parseCoordinates reads and range-checks the required lat/lng pair.
+//
+// Swapped coordinates are the most common integration mistake (GeoJSON and
+// PostGIS use lng/lat, our query string uses lat/lng). A latitude that is out
+// of range but would be a valid longitude, paired with a longitude that would
+// be a valid latitude, is reported as a likely swap; with auto_swap=true the
+// pair is corrected instead.
+func parseCoordinates(q url.Values) (lat, lng float64, apiErr *apiError) {
+	centerLatStr := q.Get("lat")
+	centerLngStr := q.Get("lng")
+	if centerLatStr == "" || centerLngStr == "" {
+		return 0, 0, badRequest(codeMissingCoordinates, "Missing latitude or longitude parameter")
+	}
+
+	var err error
+	// NaN passes every range comparison below, so non-finite values are rejected up front
+	if lat, err = strconv.ParseFloat(centerLatStr, 64); err != nil || !isFinite(lat) {
+		return 0, 0, badRequest(codeInvalidLatitude, "invalid latitude: %q is not a number", centerLatStr)
+	}
+	if lng, err = strconv.ParseFloat(centerLngStr, 64); err != nil || !isFinite(lng) {
+		return 0, 0, badRequest(codeInvalidLongitude, "invalid longitude: %q is not a number", centerLngStr)
+	}
+
+	if lat < -90 || lat > 90 {
+		if lat < -180 || lat > 180 || lng < -90 || lng > 90 {
+			return 0, 0, badRequest(codeOutOfRangeLatitude, "latitude %v is out of range [-90, 90]", lat)
+		}
+		autoSwap, apiErr := parseBoolParam(q, "auto_swap", false)
+		if apiErr != nil {
+			return 0, 0, apiErr
+		}
+		if !autoSwap {
+			return 0, 0, badRequest(codeOutOfRangeLatitude,
+				"latitude %v is out of range [-90, 90] — did you swap lat/lng? (pass auto_swap=true to correct automatically)", lat)
+		}
+		log.Printf("WARNING: auto-swapping lat=%v lng=%v, the client sent them in lng/lat order", lat, lng)
+		lat, lng = lng, lat
+	}
+	if lng < -180 || lng > 180 {
+		return 0, 0, badRequest(codeOutOfRangeLongitude, "longitude %v is out of range [-180, 180]", lng)
+	}
+	return lat, lng, nil
+}
+
+// parseUnit reads the `unit` distances are returned in, km by default.
+func parseUnit(q url.Values) (string, *apiError) {
+	unit := q.Get("unit")
+	if unit == "" {
+		return "km", nil
+	}
+	if _, ok := distanceUnits[unit]; !ok {
+		return "", badRequest(codeInvalidUnit, "unsupported unit %q, expected km or mi", unit)
+	}
+	return unit, nil
+}
+
+// isFinite reports whether v is neither NaN nor infinite. strconv.ParseFloat
+// accepts "NaN" and "Inf", which slip through range checks written as
+// v < lo || v > hi.
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// whereClause is one validated column/operator/value triple of the `where` parameter.
+type whereClause struct {
+	Column string
+	Op     string
+	Value  string
+}
+
+// whereOperators are the comparison operators allowed in `where`, mapped to SQL.
+var whereOperators = map[string]string{
+	"=":  "=",
+	"!=": "<>",
+	"<":  "<",
+	"<=": "<=",
+	">":  ">",
+	">=": ">=",
+}
+
+// whereClausePattern is the grammar of a single clause: column, operator, value.
+// Two-character operators come first so "a>=1" is not read as "a>" "=1".
+var whereClausePattern = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\s*(>=|<=|!=|=|<|>)\s*(.+)$`)
+
+// parseWhere parses a comma separated list of clauses like "capacity>=10".
+// Columns must be filterable on the dataset; ordering operators need a
+// numeric value so a typo surfaces as a 400 rather than a database error.
+func parseWhere(raw string, ds *dataset) ([]whereClause, *apiError) {
+	var clauses []whereClause
+	for _, part := range strings.Split(raw, ",") {
+		m := whereClausePattern.FindStringSubmatch(strings.TrimSpace(part))
+		if m == nil {
+			return nil, badRequest(codeInvalidFilter, "invalid where clause %q, expected <column><op><value> with op one of = != < <= > >=", part)
+		}
+		c := whereClause{Column: m[1], Op: m[2], Value: strings.TrimSpace(m[3])}
+		if !ds.filterable(c.Column) {
+			return nil, badRequest(codeInvalidFilter, "column %q is not filterable on dataset %q", c.Column, ds.Key)
+		}
+		if c.Op != "=" && c.Op != "!=" {
+			if _, err := strconv.ParseFloat(c.Value, 64); err != nil {
+				return nil, badRequest(codeInvalidFilter, "operator %s on %q needs a numeric value, got %q", c.Op, c.Column, c.Value)
+			}
+		}
+		clauses = append(clauses, c)
+	}
+	return clauses, nil
+}
+
+// parseBBox reads a minLng,minLat,maxLng,maxLat viewport. A minLng greater
+// than maxLng is a viewport crossing the antimeridian (e.g. 170,-20,-170,20).
+func parseBBox(raw string) (*tileBounds, *apiError) {
+	parts := strings.Split(raw, ",")
+	if len(parts) != 4 {
+		return nil, badRequest(codeInvalidBBox, "bbox must be minLng,minLat,maxLng,maxLat, got %q", raw)
+	}
+	var v [4]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || !isFinite(f) {
+			return nil, badRequest(codeInvalidBBox, "bbox value %q is not a number", part)
+		}
+		v[i] = f
+	}
+	b := &tileBounds{MinLng: v[0], MinLat: v[1], MaxLng: v[2], MaxLat: v[3]}
+	switch {
+	case !inRange(b.MinLng, 180) || !inRange(b.MaxLng, 180) || !inRange(b.MinLat, 90) || !inRange(b.MaxLat, 90):
+		return nil, badRequest(codeInvalidBBox, "bbox %q is outside [-180, -90, 180, 90]", raw)
+	case b.MinLat >= b.MaxLat:
+		return nil, badRequest(codeInvalidBBox, "bbox %q must have minLat below maxLat", raw)
+	case b.MinLng == b.MaxLng:
+		return nil, badRequest(codeInvalidBBox, "bbox %q has no width, minLng equals maxLng", raw)
+	}
+	return b, nil
+}
+
+// inRange reports whether v lies within [-limit, limit].
+func inRange(v, limit float64) bool {
+	return v >= -limit && v <= limit
+}
+
+// parseBoolParam reads an optional boolean query parameter, returning def when absent.
+func parseBoolParam(q url.Values, name string, def bool) (bool, *apiError) {
+	raw := q.Get(name)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return def, badRequest(codeInvalidParameter, "%s must be true or false, got %q", name, raw)
+	}
+	return v, nil
+}
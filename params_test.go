@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseBBox(t *testing.T) {
+	for _, tc := range []struct {
+		raw  string
+		want *tileBounds // nil when the bbox must be rejected
+	}{
+		{"-98,30,-97,31", &tileBounds{MinLng: -98, MinLat: 30, MaxLng: -97, MaxLat: 31}},
+		{"-180,-90,180,90", &tileBounds{MinLng: -180, MinLat: -90, MaxLng: 180, MaxLat: 90}},
+		{" -98 , 30 , -97 , 31 ", &tileBounds{MinLng: -98, MinLat: 30, MaxLng: -97, MaxLat: 31}},
+		// Crossing the antimeridian
+		{"170,-20,-170,20", &tileBounds{MinLng: 170, MinLat: -20, MaxLng: -170, MaxLat: 20}},
+		{"180,-20,-180,20", &tileBounds{MinLng: 180, MinLat: -20, MaxLng: -180, MaxLat: 20}},
+
+		// Every coordinate is range-checked on its own, crossing or not
+		{"190,-20,-170,20", nil},
+		{"170,-20,-190,20", nil},
+		{"-190,30,-97,31", nil},
+		{"-98,30,181,31", nil},
+		{"-98,-91,-97,31", nil},
+		{"-98,30,-97,91", nil},
+		{"-98,95,-97,96", nil},
+		{"-98,-96,-97,-95", nil},
+
+		{"-98,31,-97,30", nil},
+		{"-98,30,-97,30", nil},
+		{"-97,30,-97,31", nil},
+		{"-98,30,-97", nil},
+		{"-98,30,-97,31,0", nil},
+		{"-98,30,x,31", nil},
+		{"-98,NaN,-97,31", nil},
+		{"-98,30,+Inf,31", nil},
+	} {
+		got, apiErr := parseBBox(tc.raw)
+		switch {
+		case tc.want == nil && apiErr == nil:
+			t.Errorf("parseBBox(%q) = %+v, want an error", tc.raw, *got)
+		case tc.want == nil && apiErr.Code != codeInvalidBBox:
+			t.Errorf("parseBBox(%q) failed with code %s, want %s", tc.raw, apiErr.Code, codeInvalidBBox)
+		case tc.want != nil && apiErr != nil:
+			t.Errorf("parseBBox(%q) failed: %s", tc.raw, apiErr.Message)
+		case tc.want != nil && *got != *tc.want:
+			t.Errorf("parseBBox(%q) = %+v, want %+v", tc.raw, *got, *tc.want)
+		}
+	}
+}
+
+func TestMinRadiusAgainstFinalRadius(t *testing.T) {
+	for _, tc := range []struct {
+		query      string
+		wantRadius int // 0 when the query must be rejected
+	}{
+		// min_radius beyond the default radius, but inside max_distance
+		{"min_radius=15000&max_distance=20", 20000},
+		{"min_radius=15000&max_distance=20&unit=mi", 32187},
+		{"min_radius=15000&radius=20000", 20000},
+		{"min_radius=5000", defaultRadiusMeters},
+
+		{"min_radius=15000", 0},
+		{"min_radius=20000&max_distance=20", 0},
+		{"min_radius=5000&max_distance=3", 0},
+		{"min_radius=5000&radius=5000", 0},
+	} {
+		q, _ := url.ParseQuery("lat=30.2672&lng=-97.7431&" + tc.query)
+		p, apiErr := parseSearchParams(q)
+		switch {
+		case tc.wantRadius == 0 && apiErr == nil:
+			t.Errorf("%s: accepted with radius %d, want an error", tc.query, p.RadiusMeters)
+		case tc.wantRadius != 0 && apiErr != nil:
+			t.Errorf("%s: %s", tc.query, apiErr.Message)
+		case tc.wantRadius != 0 && p.RadiusMeters != tc.wantRadius:
+			t.Errorf("%s: radius %d, want %d", tc.query, p.RadiusMeters, tc.wantRadius)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// contentTypeProtobuf is the media type of format=pbf search results.
+const contentTypeProtobuf = "application/x-protobuf"
+
+// pbfFeature is the row shape buildSearchQuery produces for format=pbf.
+type pbfFeature struct {
+	ID         json.RawMessage            `json:"id"`
+	Lng        float64                    `json:"lng"`
+	Lat        float64                    `json:"lat"`
+	Properties map[string]json.RawMessage `json:"properties"`
+}
+
+// Protobuf wire types used by proto/locator.proto.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// encodeSearchPBF converts the JSON features of a format=pbf search into a
+// locator.FeatureCollection message. The schema is small enough that a
+// hand-written encoder beats pulling in a protobuf runtime.
+func encodeSearchPBF(features string, capped bool) ([]byte, error) {
+	var list []pbfFeature
+	if err := json.Unmarshal([]byte(features), &list); err != nil {
+		return nil, fmt.Errorf("decoding features: %w", err)
+	}
+
+	var out []byte
+	for _, f := range list {
+		out = appendPBFBytes(out, 1, encodeFeaturePBF(f))
+	}
+	if capped {
+		out = appendPBFTag(out, 2, wireVarint)
+		out = binary.AppendUvarint(out, 1)
+	}
+	return out, nil
+}
+
+// encodeFeaturePBF encodes one locator.Feature.
+func encodeFeaturePBF(f pbfFeature) []byte {
+	var msg []byte
+	msg = appendPBFBytes(msg, 1, []byte(pbfText(f.ID)))
+	msg = appendPBFTag(msg, 2, wireFixed64)
+	msg = binary.LittleEndian.AppendUint64(msg, math.Float64bits(f.Lng))
+	msg = appendPBFTag(msg, 3, wireFixed64)
+	msg = binary.LittleEndian.AppendUint64(msg, math.Float64bits(f.Lat))
+
+	// Sorted so identical results encode to identical bytes (and content hashes)
+	keys := make([]string, 0, len(f.Properties))
+	for k, v := range f.Properties {
+		if string(v) != "null" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// Map fields are repeated entry messages with key = 1, value = 2
+		var entry []byte
+		entry = appendPBFBytes(entry, 1, []byte(k))
+		entry = appendPBFBytes(entry, 2, []byte(pbfText(f.Properties[k])))
+		msg = appendPBFBytes(msg, 4, entry)
+	}
+	return msg
+}
+
+// pbfText renders a JSON value as protobuf string content: strings are
+// unquoted, anything else keeps its JSON text.
+func pbfText(v json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(v, &s); err == nil {
+		return s
+	}
+	return string(v)
+}
+
+func appendPBFTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wireType))
+}
+
+func appendPBFBytes(b []byte, field int, data []byte) []byte {
+	b = appendPBFTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
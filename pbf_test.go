@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+// The test decodes by hand with the field numbers below; check they are the
+// ones proto/locator.proto declares.
+var pbfSchema = map[string]string{
+	"FeatureCollection.features": "repeated Feature features = 1",
+	"FeatureCollection.capped":   "bool capped = 2",
+	"Feature.id":                 "string id = 1",
+	"Feature.lng":                "double lng = 2",
+	"Feature.lat":                "double lat = 3",
+	"Feature.properties":         "map<string, string> properties = 4",
+}
+
+func TestPBFSchemaMatchesProto(t *testing.T) {
+	raw, err := os.ReadFile("proto/locator.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for field, decl := range pbfSchema {
+		if !regexp.MustCompile(`(?m)^\s*` + regexp.QuoteMeta(decl) + `;`).Match(raw) {
+			t.Errorf("proto/locator.proto does not declare %s as %q", field, decl)
+		}
+	}
+}
+
+type decodedFeature struct {
+	ID         string
+	Lng, Lat   float64
+	Properties map[string]string
+}
+
+// pbfFields splits a message into its fields, calling fn with the field
+// number and either the varint/fixed64 value or the length-delimited bytes.
+func pbfFields(msg []byte, fn func(field int, num uint64, data []byte) error) error {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return fmt.Errorf("bad tag")
+		}
+		msg = msg[n:]
+		field := int(tag >> 3)
+		switch tag & 7 {
+		case wireVarint:
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return fmt.Errorf("field %d: bad varint", field)
+			}
+			msg = msg[n:]
+			if err := fn(field, v, nil); err != nil {
+				return err
+			}
+		case wireFixed64:
+			if len(msg) < 8 {
+				return fmt.Errorf("field %d: short fixed64", field)
+			}
+			v := binary.LittleEndian.Uint64(msg)
+			msg = msg[8:]
+			if err := fn(field, v, nil); err != nil {
+				return err
+			}
+		case wireBytes:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return fmt.Errorf("field %d: bad length", field)
+			}
+			data := msg[n : n+int(l)]
+			msg = msg[n+int(l):]
+			if err := fn(field, 0, data); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("field %d: unexpected wire type %d", field, tag&7)
+		}
+	}
+	return nil
+}
+
+func decodeSearchPBF(msg []byte) (features []decodedFeature, capped bool, err error) {
+	err = pbfFields(msg, func(field int, num uint64, data []byte) error {
+		switch field {
+		case 1:
+			f := decodedFeature{Properties: map[string]string{}}
+			err := pbfFields(data, func(field int, num uint64, data []byte) error {
+				switch field {
+				case 1:
+					f.ID = string(data)
+				case 2:
+					f.Lng = math.Float64frombits(num)
+				case 3:
+					f.Lat = math.Float64frombits(num)
+				case 4:
+					var k, v string
+					if err := pbfFields(data, func(field int, _ uint64, data []byte) error {
+						if field == 1 {
+							k = string(data)
+						} else if field == 2 {
+							v = string(data)
+						}
+						return nil
+					}); err != nil {
+						return err
+					}
+					f.Properties[k] = v
+				default:
+					return fmt.Errorf("unknown Feature field %d", field)
+				}
+				return nil
+			})
+			features = append(features, f)
+			return err
+		case 2:
+			capped = num != 0
+		default:
+			return fmt.Errorf("unknown FeatureCollection field %d", field)
+		}
+		return nil
+	})
+	return features, capped, err
+}
+
+func TestEncodeSearchPBFRoundTrip(t *testing.T) {
+	features := `[
+		{"id": 12, "lng": -97.7431, "lat": 30.2672,
+		 "properties": {"name": "Depot \"Nord\"", "bins": 3, "open": true, "note": null, "ratio": 0.5}},
+		{"id": "a-7", "lng": 0, "lat": -0.000001, "properties": {}}
+	]`
+	msg, err := encodeSearchPBF(features, true)
+	if err != nil {
+		t.Fatalf("encodeSearchPBF: %v", err)
+	}
+	got, capped, err := decodeSearchPBF(msg)
+	if err != nil {
+		t.Fatalf("decoding %x: %v", msg, err)
+	}
+	want := []decodedFeature{
+		{ID: "12", Lng: -97.7431, Lat: 30.2672,
+			Properties: map[string]string{"name": `Depot "Nord"`, "bins": "3", "open": "true", "ratio": "0.5"}},
+		{ID: "a-7", Lng: 0, Lat: -0.000001, Properties: map[string]string{}},
+	}
+	if !reflect.DeepEqual(got, want) || !capped {
+		t.Errorf("round trip gave %+v (capped %v), want %+v (capped true)", got, capped, want)
+	}
+
+	// capped is omitted when false, and an empty result is an empty message
+	if msg, err = encodeSearchPBF(`[]`, false); err != nil || len(msg) != 0 {
+		t.Errorf("empty result encoded to %x (err %v), want no bytes", msg, err)
+	}
+}
+
+func TestEncodeSearchPBFDeterministic(t *testing.T) {
+	features := `[{"id": 1, "lng": 1, "lat": 2, "properties": {"c": 1, "a": 2, "b": 3, "d": 4, "e": 5}}]`
+	first, err := encodeSearchPBF(features, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 20 {
+		if again, _ := encodeSearchPBF(features, false); string(again) != string(first) {
+			t.Fatalf("encoding changed between runs: %x vs %x", first, again)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxLoggedArgLength truncates long bound values (e.g. corridor routes) in debug logs.
+const maxLoggedArgLength = 64
+
+// parseLogLevel maps LOG_LEVEL (debug, info, warn, error) to a slog level.
+func parseLogLevel(raw string) (slog.Level, error) {
+	var level slog.Level
+	if raw == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(raw)); err != nil {
+		return slog.LevelInfo, fmt.Errorf("invalid LOG_LEVEL %q: %w", raw, err)
+	}
+	return level, nil
+}
+
+// logQuery logs a query and its bound parameters at debug level. Parameters
+// are separate fields ($1, $2, ...), never interpolated into the SQL.
+// Coordinates are rounded to two decimals (~1 km) so debug logs do not
+// record precise user locations, and long values are truncated.
+func logQuery(ctx context.Context, name, query string, args []interface{}) {
+	logger := requestLogger(ctx)
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	attrs := []any{"name", name, "sql", strings.Join(strings.Fields(query), " ")}
+	for i, arg := range args {
+		attrs = append(attrs, "$"+strconv.Itoa(i+1), redactArg(arg))
+	}
+	logger.Debug("query", attrs...)
+}
+
+// redactArg renders a bound parameter for the debug log.
+func redactArg(arg interface{}) string {
+	var s string
+	switch v := arg.(type) {
+	case float64:
+		s = strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+	default:
+		s = fmt.Sprint(v)
+	}
+	if len(s) > maxLoggedArgLength {
+		s = s[:maxLoggedArgLength] + "…"
+	}
+	return s
+}
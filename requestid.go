@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// requestIDHeader carries the id that correlates a request across the gateway and this service.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength caps client supplied ids so they cannot bloat every log line.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// newRequestID returns a random (version 4) UUID.
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand does not fail on supported platforms; an empty id just means no correlation
+		return ""
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// requestIDFromContext returns the id assigned by withRequestID, or "".
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLogger returns the default logger tagged with the request id, so
+// per-request log lines can be correlated with the access log.
+func requestLogger(ctx context.Context) *slog.Logger {
+	if id := requestIDFromContext(ctx); id != "" {
+		return slog.With("request_id", id)
+	}
+	return slog.Default()
+}
+
+// withRequestID accepts the caller's X-Request-ID (generating one when absent),
+// stores it in the request context and echoes it in the response header.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
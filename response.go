@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Content types of API responses. JSON is UTF-8 by definition, the charset is
+// spelled out for clients that otherwise guess.
+const (
+	contentTypeJSON    = "application/json; charset=utf-8"
+	contentTypeGeoJSON = "application/geo+json; charset=utf-8"
+	contentTypeNDJSON  = "application/x-ndjson; charset=utf-8"
+)
+
+// contentHashHeader carries the hex SHA-256 of the response body, so clients
+// that cache payloads can verify them later. It is always computed over the
+// uncompressed body, whether or not the transfer was gzipped.
+const contentHashHeader = "X-Content-SHA256"
+
+// writeBody writes a complete response body with an explicit Content-Length,
+// so small and large responses alike avoid chunked transfer encoding.
+// (withGzip drops the length again when it compresses the body.)
+func writeBody(w http.ResponseWriter, body string) {
+	setBodyHeaders(w, []byte(body))
+	io.WriteString(w, body)
+}
+
+// setBodyHeaders sets the Content-Length and content hash headers of body.
+func setBodyHeaders(w http.ResponseWriter, body []byte) {
+	sum := sha256.Sum256(body)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set(contentHashHeader, hex.EncodeToString(sum[:]))
+}
+
+// writeJSON marshals v and writes it with writeBody.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeAPIError(w, &apiError{Status: http.StatusInternalServerError, Code: codeInternalError, Message: "encoding response: " + err.Error()})
+		return
+	}
+	writeBody(w, string(body))
+}
+
+// collectionMetadata renders the "metadata" foreign member of a bare
+// FeatureCollection (metadata=true): the query as resolved by the server,
+// the number of features and when the document was generated. RFC 7946
+// allows foreign members, so the document stays valid GeoJSON.
+func collectionMetadata(r *http.Request, p searchParams, result searchResult) string {
+	params := map[string]string{}
+	for key, values := range r.URL.Query() {
+		params[key] = values[0]
+	}
+	type query struct {
+		Dataset      string            `json:"dataset"`
+		Lat          float64           `json:"lat"`
+		Lng          float64           `json:"lng"`
+		RadiusMeters int               `json:"radius_meters"`
+		Unit         string            `json:"unit"`
+		Limit        int               `json:"limit"`
+		Parameters   map[string]string `json:"parameters"`
+	}
+	meta, _ := json.Marshal(struct {
+		Query       query     `json:"query"`
+		TotalCount  int       `json:"total_count"`
+		Capped      bool      `json:"capped"`
+		GeneratedAt time.Time `json:"generated_at"`
+	}{
+		Query:       query{p.Dataset.Key, p.Lat, p.Lng, result.RadiusMeters, p.Unit, p.Limit, params},
+		TotalCount:  result.Count,
+		Capped:      result.Capped,
+		GeneratedAt: time.Now().UTC().Truncate(time.Second),
+	})
+	return string(meta)
+}
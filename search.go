@@ -0,0 +1,555 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// distanceUnit describes how the ST_Distance result (always meters on geography)
+// is converted and which property name it is exposed under.
+type distanceUnit struct {
+	divisor float64
+	column  string
+}
+
+// distanceUnits holds the supported values of the `unit` query parameter.
+// The conversion happens inside PostGIS so the property is never re-rounded in Go.
+var distanceUnits = map[string]distanceUnit{
+	"km": {divisor: 1000, column: "distance_km"},
+	"mi": {divisor: 1609.344, column: "distance_mi"},
+}
+
+// etaSpeeds are the default straight-line travel speeds (km/h) of the `eta` modes.
+var etaSpeeds = map[string]float64{
+	"walk":  5,
+	"drive": 40,
+}
+
+// maxETASpeed bounds the `speed` override in km/h.
+const maxETASpeed = 200
+
+// Bounds of the `elongation` factor of directional searches. Beyond a factor
+// of ~10 the ellipse degenerates into a line and the ordering stops making sense.
+const (
+	defaultElongation = 2
+	maxElongation     = 10
+)
+
+// geometryFormatGeoJSON is the default `geometry_format`.
+const geometryFormatGeoJSON = "geojson"
+
+// geometryFormats maps the supported values of the `geometry_format` query
+// parameter to the SQL that serializes a WGS84 geometry expression as jsonb.
+// EWKB is binary, so it is base64 encoded (without the line breaks encode() adds).
+var geometryFormats = map[string]func(geom string) string{
+	geometryFormatGeoJSON: func(geom string) string {
+		return fmt.Sprintf("ST_AsGeoJSON(%s)::jsonb", geom)
+	},
+	"wkt": func(geom string) string {
+		return fmt.Sprintf("to_jsonb(ST_AsText(%s))", geom)
+	},
+	"ewkb": func(geom string) string {
+		return fmt.Sprintf("to_jsonb(translate(encode(ST_AsEWKB(%s), 'base64'), E'\\n', ''))", geom)
+	},
+}
+
+// defaultDistancePrecision is the number of decimals distance properties are
+// rounded to; DISTANCE_PRECISION overrides it.
+const defaultDistancePrecision = 2
+
+// distancePrecision is the configured number of decimals for distance properties.
+var distancePrecision = defaultDistancePrecision
+
+// queryArgs collects bound parameters while a query is assembled.
+// add returns the $N placeholder for the value it appended.
+type queryArgs []interface{}
+
+func (a *queryArgs) add(v interface{}) string {
+	*a = append(*a, v)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// searchResult is the outcome of a radius search.
+type searchResult struct {
+	Features string // raw JSON array of features (GeoJSON, or plain objects for format=flat)
+	Count    int    // number of features in Features
+	Capped   bool   // true when more matches exist beyond the limit
+
+	RadiusMeters int  // radius actually searched
+	Expanded     bool // auto_expand widened the radius to find results
+}
+
+// auto_expand limits: the radius doubles at most autoExpandMaxSteps times and
+// never beyond autoExpandMaxRadius (AUTO_EXPAND_MAX_STEPS / AUTO_EXPAND_MAX_RADIUS).
+const (
+	defaultAutoExpandMaxSteps  = 3
+	defaultAutoExpandMaxRadius = 80000
+)
+
+var (
+	autoExpandMaxSteps  = defaultAutoExpandMaxSteps
+	autoExpandMaxRadius = defaultAutoExpandMaxRadius
+)
+
+// searchFilter builds the WHERE clause shared by every query honoring the
+// /api/search filters. Placeholders are allocated on first use, so a query
+// only binds the parameters it actually references.
+type searchFilter struct {
+	p      searchParams
+	args   queryArgs
+	center string
+}
+
+func newSearchFilter(p searchParams) *searchFilter {
+	return &searchFilter{p: p}
+}
+
+// centerGeog returns the search center as a geography expression.
+func (f *searchFilter) centerGeog() string {
+	if f.center == "" {
+		f.center = fmt.Sprintf("ST_SetSRID(ST_MakePoint(%s, %s), 4326)::geography", f.args.add(f.p.Lng), f.args.add(f.p.Lat))
+	}
+	return f.center
+}
+
+// distance returns the distance in meters between the row geometry and the
+// search center, on the spheroid or, with distance_mode=planar, in the UTM
+// zone of the center (see planarSRID).
+func (f *searchFilter) distance() string {
+	if f.p.DistanceMode == distanceModePlanar {
+		srid := planarSRID(f.p.Lat, f.p.Lng)
+		return fmt.Sprintf("ST_Distance(ST_Transform(%s, %d), ST_Transform(%s::geometry, %d))",
+			f.p.Dataset.geom(), srid, f.centerGeog(), srid)
+	}
+	return fmt.Sprintf("ST_Distance(%s::geography, %s)", f.p.Dataset.geom(), f.centerGeog())
+}
+
+// dwithin returns the predicate keeping rows within radius meters (an SQL
+// expression, usually a placeholder) of the center, in the distance mode.
+func (f *searchFilter) dwithin(radius string) string {
+	if f.p.DistanceMode == distanceModePlanar {
+		srid := planarSRID(f.p.Lat, f.p.Lng)
+		return fmt.Sprintf("ST_DWithin(ST_Transform(%s, %d), ST_Transform(%s::geometry, %d), %s)",
+			f.p.Dataset.geom(), srid, f.centerGeog(), srid, radius)
+	}
+	return fmt.Sprintf("ST_DWithin(%s::geography, %s, %s)", f.p.Dataset.geom(), f.centerGeog(), radius)
+}
+
+// planarSRID is the WGS84 / UTM zone SRID (EPSG:326xx north, 327xx south)
+// containing lat/lng, whose meters are within 0.1% of the true distance
+// across the zone.
+func planarSRID(lat, lng float64) int {
+	zone := int(math.Floor((lng+180)/6)) + 1
+	if zone > 60 {
+		zone = 60 // lng = 180 belongs to the last zone
+	}
+	if lat < 0 {
+		return 32700 + zone
+	}
+	return 32600 + zone
+}
+
+// where returns the spatial and attribute predicates, ANDed together.
+// Rows with a NULL geometry (bad imports) are skipped rather than breaking the whole search.
+func (f *searchFilter) where() string {
+	p, ds := f.p, f.p.Dataset
+
+	where := []string{ds.geomCol() + " IS NOT NULL"}
+	if p.RadiusMeters > 0 {
+		where = append(where, f.dwithin(f.args.add(p.RadiusMeters)))
+	}
+	if p.MinRadiusMeters > 0 {
+		where = append(where, fmt.Sprintf("%s >= %s", f.distance(), f.args.add(p.MinRadiusMeters)))
+	}
+	if p.WithinBoundary != "" {
+		where = append(where, ds.Boundaries.withinExpr(ds.geom(), f.args.add(p.WithinBoundary)))
+	}
+	if p.BBox != nil {
+		// Same test as the tile endpoints: && on the native column for the index, then the exact check.
+		// A viewport crossing the antimeridian is split in two envelopes, ORed.
+		var tests []string
+		for _, b := range p.BBox.split() {
+			env := ds.nativeEnvelope(f.args.add(b.MinLng), f.args.add(b.MinLat), f.args.add(b.MaxLng), f.args.add(b.MaxLat))
+			tests = append(tests, fmt.Sprintf("(%[1]s && %[2]s AND ST_Intersects(%[1]s, %[2]s))", ds.geomCol(), env))
+		}
+		where = append(where, "("+strings.Join(tests, " OR ")+")")
+	}
+	if p.ExcludePolygon != "" {
+		where = append(where, fmt.Sprintf("NOT ST_Within(%s, ST_SetSRID(ST_GeomFromGeoJSON(%s), 4326))",
+			ds.geom(), f.args.add(p.ExcludePolygon)))
+	}
+	if len(p.Categories) > 0 {
+		where = append(where, fmt.Sprintf("%s = ANY(%s)",
+			pq.QuoteIdentifier(ds.CategoryColumn), f.args.add(pq.Array(p.Categories))))
+	}
+	for _, col := range p.Has {
+		// Column names are allowlisted in parseSearchParams; the ::text cast makes '' valid for any type
+		where = append(where, fmt.Sprintf("%[1]s IS NOT NULL AND %[1]s::text <> ''", pq.QuoteIdentifier(col)))
+	}
+	for _, c := range p.Where {
+		// Operators are allowlisted and values always bound, never interpolated
+		where = append(where, fmt.Sprintf("%s %s %s", pq.QuoteIdentifier(c.Column), whereOperators[c.Op], f.args.add(c.Value)))
+	}
+	return strings.Join(where, "\n\t\t\t\tAND ")
+}
+
+// nearestPoint returns the jsonb adding nearest_point, the [lng, lat] of the
+// point of the row geometry closest to the center, for line and polygon
+// datasets (e.g. the park entrance side rather than the park's middle). It is
+// empty for point datasets. Distances already use the full geometry, so they
+// are to that point (0 inside a polygon), never to a centroid.
+func (f *searchFilter) nearestPoint() string {
+	if !f.p.Dataset.extended() {
+		return ""
+	}
+	cp := fmt.Sprintf("ST_ClosestPoint(%s, %s::geometry)", f.p.Dataset.geom(), f.centerGeog())
+	return fmt.Sprintf("jsonb_build_object('nearest_point', jsonb_build_array(ST_X(%[1]s), ST_Y(%[1]s)))", cp)
+}
+
+// bearingWeight returns the factor applied to the distance of a row when
+// ordering along a bearing, or "" when the search has none.
+//
+// With θ the azimuth from the center to the feature and β the bearing, the
+// distance d splits into an along-bearing part d·cos(θ-β) and a cross part
+// d·sin(θ-β). Shrinking the along part by the elongation e gives the
+// weighted distance d·sqrt(cos²(θ-β)/e² + sin²(θ-β)): equal weighted
+// distances form an ellipse e times longer along the bearing than across it,
+// in both directions (a road runs both ways). A feature at the center has no
+// azimuth and keeps weight 1. The result is only used for ordering, the
+// returned distance and the radius filter stay circular.
+func (f *searchFilter) bearingWeight() string {
+	p, ds := f.p, f.p.Dataset
+	if p.Bearing == nil {
+		return ""
+	}
+	angle := fmt.Sprintf("(ST_Azimuth(%s, ST_PointOnSurface(%s)::geography) - radians(%s::float8))",
+		f.centerGeog(), ds.geom(), f.args.add(*p.Bearing))
+	return fmt.Sprintf("COALESCE(sqrt(power(cos(%[1]s), 2) / power(%[2]s::float8, 2) + power(sin(%[1]s), 2)), 1)",
+		angle, f.args.add(p.Elongation))
+}
+
+// candidateRanks implements the filters that rank rows within a partition:
+//   - per_category_limit keeps only the nearest N rows of each category to
+//     diversify results;
+//   - dedupe_by keeps the nearest row of each business key (chain duplicates),
+//     like DISTINCT ON but composable with the rest of the query. Rows without
+//     a key are never merged.
+//
+// It returns the extra select columns (empty when unused), the predicate to
+// apply on top of them and the helper column names to hide from properties.
+func (f *searchFilter) candidateRanks() (rankSelect, candidateWhere string, hidden []string) {
+	p, ds := f.p, f.p.Dataset
+	candidateWhere = "TRUE"
+	if p.DedupeBy != "" {
+		key := pq.QuoteIdentifier(p.DedupeBy)
+		rankSelect += fmt.Sprintf(",\n\t\t\t\t\t\tROW_NUMBER() OVER (PARTITION BY %s ORDER BY %s) AS _dedupe_rank", key, f.distance())
+		candidateWhere += fmt.Sprintf(" AND (_dedupe_rank = 1 OR %s IS NULL)", key)
+		hidden = append(hidden, "_dedupe_rank")
+	}
+	if p.PerCategoryLimit > 0 {
+		// Counted before deduplication, so duplicates may use up a category's share
+		rankSelect += fmt.Sprintf(",\n\t\t\t\t\t\tROW_NUMBER() OVER (PARTITION BY %s ORDER BY %s) AS _category_rank",
+			pq.QuoteIdentifier(ds.CategoryColumn), f.distance())
+		candidateWhere += " AND _category_rank <= " + f.args.add(p.PerCategoryLimit)
+		hidden = append(hidden, "_category_rank")
+	}
+	return rankSelect, candidateWhere, hidden
+}
+
+// countSearchResults counts the rows a search would return (up to the limit)
+// without building any features, for HEAD requests.
+func countSearchResults(ctx context.Context, p searchParams) (count int, capped bool, err error) {
+	ds := p.Dataset
+	f := newSearchFilter(p)
+	where := f.where()
+	rankSelect, candidateWhere, _ := f.candidateRanks()
+	limit := f.args.add(p.Limit)
+
+	var queryStr = fmt.Sprintf(
+		`SELECT LEAST(count(*), %[1]s), count(*) > %[1]s
+		FROM (
+			SELECT 1
+			FROM (
+				SELECT *%[2]s
+				FROM %[3]s
+				WHERE %[4]s
+			) candidates
+			WHERE %[5]s
+			LIMIT %[1]s + 1
+		) t;
+		`, limit, rankSelect, ds.quotedTable(), where, candidateWhere)
+
+	logQuery(ctx, "search_count", queryStr, f.args)
+	if err := readDB.QueryRowContext(ctx, queryStr, f.args...).Scan(&count, &capped); err != nil {
+		return 0, false, fmt.Errorf("error scanning row: %w", err)
+	}
+	return count, capped, nil
+}
+
+// searchQuery is a search compiled to SQL. rows yields one feature per row,
+// ordered by n, including the extra LIMIT+1 row that only signals capping.
+type searchQuery struct {
+	rows  string
+	limit string // placeholder of the limit
+	args  queryArgs
+}
+
+// buildSearchQuery compiles the search into the per-row feature query shared
+// by the aggregated and the streaming path.
+func buildSearchQuery(p searchParams) (searchQuery, error) {
+	du, ok := distanceUnits[p.Unit]
+	if !ok {
+		return searchQuery{}, fmt.Errorf("unsupported unit: %q", p.Unit)
+	}
+	ds := p.Dataset
+	f := newSearchFilter(p)
+	where := f.where()
+	limit := f.args.add(p.Limit)
+
+	// Helper columns like the category rank must not leak into the feature properties.
+	helperSelect, candidateWhere, hidden := f.candidateRanks()
+
+	// Nearest first; boost floats featured rows to the top and sort=popularity
+	// puts the most popular first, with distance breaking ties.
+	// With a bearing, "nearest" is measured by the direction-weighted distance.
+	// Without an explicit sort the dataset's default_order applies.
+	orderBy := du.column
+	if weight := f.bearingWeight(); weight != "" {
+		helperSelect += fmt.Sprintf(",\n\t\t\t\t\t\t%s * %s AS _bearing_distance", f.distance(), weight)
+		hidden = append(hidden, "_bearing_distance")
+		orderBy = "_bearing_distance"
+	}
+	if p.Sort == "" && len(ds.DefaultOrder) > 0 {
+		orderBy = ds.defaultOrderBy(orderBy)
+	}
+	if p.Sort == sortPopularity {
+		orderBy = fmt.Sprintf("%s DESC NULLS LAST, %s", pq.QuoteIdentifier(ds.PopularityColumn), orderBy)
+	}
+	if p.Boost {
+		orderBy = fmt.Sprintf("%s DESC NULLS LAST, %s", pq.QuoteIdentifier(ds.FeaturedColumn), orderBy)
+	}
+
+	// The distance is rounded only when serialized, ordering still uses the exact value
+	props := fmt.Sprintf("(%s) || jsonb_build_object(%s, round(row.%s::numeric, %d))",
+		ds.propertiesExpr("row", hidden...), pq.QuoteLiteral(du.column), du.column, distancePrecision)
+	if loc := ds.localizedExpr("row", p.Lang); loc != "" {
+		props += " || " + loc
+	}
+	if np := f.nearestPoint(); np != "" {
+		props += " || " + np
+	}
+	// rank is the 1-based position in the output, so client-side numbering survives re-sorting
+	props += fmt.Sprintf(" || jsonb_build_object('rank', ROW_NUMBER() OVER (ORDER BY %s))", orderBy)
+	if p.AllUnits {
+		// Every other unit is derived from the same distance, so they never disagree
+		for _, name := range []string{"km", "mi"} {
+			if other := distanceUnits[name]; other.column != du.column {
+				props += fmt.Sprintf(" || jsonb_build_object(%s, round((row.%s * %v / %v)::numeric, %d))",
+					pq.QuoteLiteral(other.column), du.column, du.divisor, other.divisor, distancePrecision)
+			}
+		}
+	}
+	if p.ETAMode != "" {
+		// Straight-line distance at a constant speed: a rough "15 min away" label, not a route
+		metersPerMinute := p.ETASpeed * 1000 / 60
+		props += fmt.Sprintf(" || jsonb_build_object('eta_min', ceil(row.%s * %v / %s::float8)::int, 'eta_mode', %s::text, 'eta_basis', 'straight_line')",
+			du.column, du.divisor, f.args.add(metersPerMinute), f.args.add(p.ETAMode))
+	}
+	if p.FeatureBBox {
+		// Same ordering as a GeoJSON bbox member; a point yields a degenerate box
+		env := fmt.Sprintf("ST_Envelope(%s)", ds.geom())
+		props += fmt.Sprintf(" || jsonb_build_object('bbox', jsonb_build_array(ST_XMin(%s), ST_YMin(%s), ST_XMax(%s), ST_YMax(%s)))",
+			env, env, env, env)
+	}
+	if p.FeatureGeomType {
+		// ST_GeometryType says ST_Point; without the prefix it matches the GeoJSON type names
+		props += fmt.Sprintf(" || jsonb_build_object('geometry_type', replace(ST_GeometryType(%s), 'ST_', ''))", ds.geom())
+	}
+
+	geometry := geometryFormats[p.GeometryFormat](ds.geom())
+	featureExpr := fmt.Sprintf(`jsonb_build_object(
+				'type', 'Feature',
+				'geometry', %s,
+				'properties', %s
+			)`, geometry, props)
+	switch {
+	case p.Format == formatPBF:
+		// Only a representative point: native clients render pins, not shapes
+		pbfProps := props
+		if p.Minimal {
+			pbfProps = "'{}'::jsonb"
+		}
+		featureExpr = fmt.Sprintf(`jsonb_build_object(
+				'id', %s,
+				'lng', ST_X(ST_PointOnSurface(%s)),
+				'lat', ST_Y(ST_PointOnSurface(%s)),
+				'properties', %s
+			)`, ds.idCol(), ds.geom(), ds.geom(), pbfProps)
+	case p.Format == formatFlat:
+		// Mobile clients get plain objects with lat/lng merged into the properties.
+		// ST_PointOnSurface keeps this valid for non-point geometries.
+		featureExpr = fmt.Sprintf(`(%s) || jsonb_build_object(
+				'lat', ST_Y(ST_PointOnSurface(%s)),
+				'lng', ST_X(ST_PointOnSurface(%s))
+			)`, props, ds.geom(), ds.geom())
+	case !p.IncludeGeometry:
+		// List-only views: no geometry member at all, id and properties only
+		featureExpr = fmt.Sprintf(`jsonb_build_object(
+				'type', 'Feature',
+				'id', %s,
+				'properties', %s
+			)`, ds.idCol(), props)
+	case p.Minimal:
+		// Pin-only views skip the properties object and only need the geometry and id
+		featureExpr = fmt.Sprintf(`jsonb_build_object(
+				'type', 'Feature',
+				'id', %s,
+				'geometry', %s
+			)`, ds.idCol(), geometry)
+	}
+
+	// This robust query filters with ST_DWithin (and any extra predicates), one feature per row.
+	// It fetches LIMIT+1 rows: the extra row is never serialized, it only tells us the result was capped.
+	rows := fmt.Sprintf(
+		`SELECT %[2]s AS feature, ROW_NUMBER() OVER (ORDER BY %[3]s) AS n
+			FROM (
+				SELECT *
+				FROM (
+					SELECT *,
+						-- Calculate distance in the requested unit (meters / divisor)
+						%[4]s / %[5]v AS %[6]s%[9]s
+					FROM %[7]s
+					WHERE %[8]s
+				) candidates
+				WHERE %[10]s
+				ORDER BY %[3]s
+				LIMIT %[1]s + 1
+			) row`, limit, featureExpr, orderBy, f.distance(), du.divisor, du.column, ds.quotedTable(), where,
+		helperSelect, candidateWhere)
+
+	return searchQuery{rows: rows, limit: limit, args: f.args}, nil
+}
+
+// getGeoJSONFromDatabase executes the PostGIS query and returns raw GeoJSON string.
+// PostgreSQL aggregates the features into a single JSON array.
+// The query is bound to ctx so it is canceled when the client goes away.
+func getGeoJSONFromDatabase(ctx context.Context, p searchParams) (searchResult, error) {
+	sq, err := buildSearchQuery(p)
+	if err != nil {
+		return searchResult{}, err
+	}
+	var queryStr = fmt.Sprintf(
+		`SELECT COALESCE(jsonb_agg(t.feature ORDER BY t.n) FILTER (WHERE t.n <= %[1]s), '[]'::jsonb), LEAST(count(*), %[1]s), count(*) > %[1]s
+		FROM (
+			%[2]s
+		) t;
+		`, sq.limit, sq.rows)
+
+	// Only logged with LOG_LEVEL=debug
+	logQuery(ctx, "search", queryStr, sq.args)
+
+	query := func(ctx context.Context) (searchResult, error) {
+		conn, err := acquireConn(ctx, readDB)
+		if err != nil {
+			return searchResult{}, err
+		}
+		defer conn.Close()
+
+		row := conn.QueryRowContext(ctx, queryStr, sq.args...)
+
+		var result searchResult
+		err = row.Scan(&result.Features, &result.Count, &result.Capped)
+
+		// Handle the case where the query returns no data (e.g., empty set)
+		if err == sql.ErrNoRows {
+			return searchResult{Features: "[]", RadiusMeters: p.RadiusMeters}, nil // Return an empty GeoJSON array
+		} else if err != nil {
+			return searchResult{}, fmt.Errorf("error scanning row: %w", err)
+		}
+
+		result.RadiusMeters = p.RadiusMeters
+		return result, nil
+	}
+
+	// Concurrent identical searches (a burst on a popular location) share one query
+	key, ok := flightKey(queryStr, sq.args)
+	if !ok {
+		return query(ctx)
+	}
+	result, shared, err := searchFlights.do(ctx, key, query)
+	if shared {
+		requestLogger(ctx).Debug("search coalesced with an identical in-flight query")
+	}
+	return result, err
+}
+
+// streamSearchResults writes the features of a search to w one row at a
+// time, comma separated, so a large result never sits in memory as one
+// string. It returns the number of features written and whether more
+// matches exist beyond the limit.
+func streamSearchResults(ctx context.Context, p searchParams, w io.Writer) (count int, capped bool, err error) {
+	sq, err := buildSearchQuery(p)
+	if err != nil {
+		return 0, false, err
+	}
+	queryStr := fmt.Sprintf("SELECT t.feature FROM (%s) t ORDER BY t.n", sq.rows)
+	logQuery(ctx, "search_stream", queryStr, sq.args)
+
+	conn, err := acquireConn(ctx, readDB)
+	if err != nil {
+		return 0, false, err
+	}
+	defer conn.Close()
+
+	rows, err := conn.QueryContext(ctx, queryStr, sq.args...)
+	if err != nil {
+		return 0, false, fmt.Errorf("error querying rows: %w", err)
+	}
+	defer rows.Close()
+
+	var feature sql.RawBytes
+	for rows.Next() {
+		if count == p.Limit {
+			// The LIMIT+1 row only tells us the result was capped
+			capped = true
+			break
+		}
+		if err := rows.Scan(&feature); err != nil {
+			return count, false, fmt.Errorf("error scanning row: %w", err)
+		}
+		if count > 0 {
+			io.WriteString(w, ",")
+		}
+		w.Write(feature)
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, false, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return count, capped, nil
+}
+
+// searchWithAutoExpand runs the search and, when auto_expand is set and
+// nothing was found, retries with a doubled radius until something matches
+// or the step/radius caps are reached.
+func searchWithAutoExpand(ctx context.Context, p searchParams) (searchResult, error) {
+	result, err := getGeoJSONFromDatabase(ctx, p)
+	if err != nil || !p.AutoExpand || p.RadiusMeters == 0 {
+		return result, err
+	}
+	for step := 0; result.Count == 0 && step < autoExpandMaxSteps && p.RadiusMeters < autoExpandMaxRadius; step++ {
+		p.RadiusMeters = min(p.RadiusMeters*2, autoExpandMaxRadius)
+		if result, err = getGeoJSONFromDatabase(ctx, p); err != nil {
+			return result, err
+		}
+		result.Expanded = true
+	}
+	return result, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"testing"
+)
+
+func TestSearchSkipsNullGeometries(t *testing.T) {
+	conn := testDB(t)
+	ds := installFixture(t, conn, testFixture{Key: "null_geoms", Rows: [][2]string{
+		{"near", "POINT(-97.7431 30.2672)"},
+		{"missing", ""},
+		{"farther", "POINT(-97.7400 30.2700)"},
+	}})
+
+	p, apiErr := parseSearchParams(url.Values{"dataset": {ds.Key}, "lat": {"30.2672"}, "lng": {"-97.7431"}})
+	if apiErr != nil {
+		t.Fatalf("parseSearchParams: %v", apiErr.Message)
+	}
+	result, err := getGeoJSONFromDatabase(context.Background(), p)
+	if err != nil {
+		t.Fatalf("search with a NULL geometry row: %v", err)
+	}
+
+	var features []struct {
+		Geometry   json.RawMessage `json:"geometry"`
+		Properties struct {
+			Name string `json:"name"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal([]byte(result.Features), &features); err != nil {
+		t.Fatalf("decoding features: %v", err)
+	}
+	var names []string
+	for _, f := range features {
+		if len(f.Geometry) == 0 || string(f.Geometry) == "null" {
+			t.Errorf("feature %q has no geometry", f.Properties.Name)
+		}
+		names = append(names, f.Properties.Name)
+	}
+	sort.Strings(names)
+	if result.Count != 2 || len(names) != 2 || names[0] != "farther" || names[1] != "near" {
+		t.Errorf("got %d features %v, want the 2 with geometries", result.Count, names)
+	}
+
+	s, err := loadDatasetSummary(ds)
+	if err != nil {
+		t.Fatalf("loadDatasetSummary: %v", err)
+	}
+	if s.Count != 3 || s.NullGeoms != 1 {
+		t.Errorf("summary counts %d rows with %d NULL geometries, want 3 and 1", s.Count, s.NullGeoms)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// SearchResponse is the v1 /api/search body app.js consumes:
+//
+//	{"status": "ok", "features": [...], "capped": false, ...}
+//
+// encode renders it with the ": " and ", " separators the hand-built
+// responses always used, so the bytes (and X-Content-SHA256 values cached by
+// clients) did not change when this type was introduced; json.Marshal gives
+// the same document compacted. New fields go into SearchMeta, which v2
+// responses share.
+type SearchResponse struct {
+	Status   string
+	Features json.RawMessage
+	Meta     SearchMeta
+}
+
+// SearchMeta is the metadata following the features: top-level fields in v1,
+// the "meta" object in v2. Optional fields are omitted when nil or empty.
+type SearchMeta struct {
+	Capped        bool    // more features exist beyond the limit
+	DataUpdatedAt *string // RFC 3339 freshness of the dataset, when it has a timestamp column
+	Expanded      *bool   // auto_expand widened the radius
+	RadiusUsed    *int    // radius the results came from, with auto_expand
+}
+
+// newSearchMeta collects the metadata of a search result.
+func newSearchMeta(p searchParams, result searchResult) SearchMeta {
+	m := SearchMeta{Capped: result.Capped}
+	// Freshness of the underlying data, omitted when the dataset has no timestamp column
+	if updatedAt, ok := dataUpdatedAt(p.Dataset.Key); ok {
+		s := updatedAt.Format(time.RFC3339)
+		m.DataUpdatedAt = &s
+	}
+	// With auto_expand the client needs to know which radius the results came from
+	if p.AutoExpand {
+		m.Expanded, m.RadiusUsed = &result.Expanded, &result.RadiusMeters
+	}
+	return m
+}
+
+// appendFields appends the metadata as `"name": value` pairs joined by ", ".
+func (m SearchMeta) appendFields(b []byte) []byte {
+	b = appendJSONField(b, "capped", m.Capped)
+	if m.DataUpdatedAt != nil {
+		b = appendJSONField(append(b, ", "...), "data_updated_at", *m.DataUpdatedAt)
+	}
+	if m.Expanded != nil {
+		b = appendJSONField(append(b, ", "...), "expanded", *m.Expanded)
+	}
+	if m.RadiusUsed != nil {
+		b = appendJSONField(append(b, ", "...), "radius_used", *m.RadiusUsed)
+	}
+	return b
+}
+
+// encode renders the v2 "meta" object.
+func (m SearchMeta) encode() []byte {
+	return append(m.appendFields([]byte("{")), '}')
+}
+
+// MarshalJSON implements json.Marshaler; see encode for the exact bytes.
+func (m SearchMeta) MarshalJSON() ([]byte, error) {
+	return m.encode(), nil
+}
+
+// envelope returns the JSON before and after the features array, so the
+// streaming path can write the features in between row by row.
+func (r SearchResponse) envelope() (prefix, suffix string) {
+	pre := appendJSONField([]byte("{"), "status", r.Status)
+	return string(pre) + `, "features": `, string(r.Meta.appendFields([]byte(", "))) + "}"
+}
+
+// encode renders the complete v1 body.
+func (r SearchResponse) encode() []byte {
+	features := r.Features
+	if features == nil {
+		features = json.RawMessage("[]")
+	}
+	prefix, suffix := r.envelope()
+	return []byte(prefix + string(features) + suffix)
+}
+
+// MarshalJSON implements json.Marshaler; see encode for the exact bytes.
+func (r SearchResponse) MarshalJSON() ([]byte, error) {
+	return r.encode(), nil
+}
+
+// appendJSONField appends `"name": <v as JSON>`. Values are the scalars of
+// SearchMeta and SearchResponse, which always marshal.
+func appendJSONField(b []byte, name string, v any) []byte {
+	key, _ := json.Marshal(name)
+	val, _ := json.Marshal(v)
+	b = append(b, key...)
+	b = append(b, ": "...)
+	return append(b, val...)
+}
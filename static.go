@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// spaHandler serves the frontend from dir and falls back to index.html for
+// unknown paths, so client-side routes (e.g. /about) survive a page refresh.
+// Unmatched /api/ and /admin/ paths still get a JSON 404 rather than the app shell.
+func spaHandler(dir string) http.HandlerFunc {
+	files := http.FileServer(http.Dir(dir))
+	index := filepath.Join(dir, "index.html")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/admin/") {
+			writeAPIError(w, &apiError{Status: http.StatusNotFound, Code: codeNotFound, Message: "no such endpoint: " + r.URL.Path})
+			return
+		}
+
+		// Existing files and directories are served as-is
+		name := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+		if _, err := os.Stat(name); err == nil {
+			files.ServeHTTP(w, r)
+			return
+		}
+		http.ServeFile(w, r, index)
+	}
+}
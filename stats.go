@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+// propertyStats are the aggregates of one numeric column over the matched
+// features. The pointers are nil when no matched row has a value.
+type propertyStats struct {
+	Count int      `json:"count"` // matched rows with a non-null value
+	Min   *float64 `json:"min"`
+	Max   *float64 `json:"max"`
+	Avg   *float64 `json:"avg"`
+	Sum   *float64 `json:"sum"`
+}
+
+// apiStatsHandler serves /api/stats: min/max/avg/sum of a numeric property
+// over the features matching a search (same parameters as /api/search), so
+// dashboards do not have to download every feature to add them up.
+func apiStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", contentTypeJSON)
+
+	q := r.URL.Query()
+	params, apiErr := parseSearchParams(q)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	field := q.Get("field")
+	if field == "" {
+		writeAPIError(w, badRequest(codeInvalidParameter, "field is required"))
+		return
+	}
+	if !params.Dataset.numeric(field) {
+		writeAPIError(w, badRequest(codeInvalidParameter, "field %q is not a numeric column of dataset %q", field, params.Dataset.Key))
+		return
+	}
+	ctx := r.Context()
+	if apiErr := resolveCenter(ctx, &params); apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	count, stats, err := getStatsFromDatabase(ctx, params, field)
+	if err != nil {
+		writeAPIError(w, queryError(ctx, err))
+		return
+	}
+	setResultCount(r, count)
+
+	writeJSON(w, struct {
+		Status string        `json:"status"`
+		Count  int           `json:"count"`
+		Field  string        `json:"field"`
+		Stats  propertyStats `json:"stats"`
+	}{"ok", count, field, stats})
+}
+
+// getStatsFromDatabase returns how many features matched and the aggregates
+// of field over them, in a single pass.
+func getStatsFromDatabase(ctx context.Context, p searchParams, field string) (int, propertyStats, error) {
+	ds := p.Dataset
+	f := newSearchFilter(p)
+	// Same cast as propertiesExpr: text columns imported from CSV still aggregate
+	value := fmt.Sprintf("NULLIF(btrim(%s::text), '')::double precision", pq.QuoteIdentifier(field))
+	var queryStr = fmt.Sprintf(
+		`SELECT count(*), count(v), min(v), max(v), avg(v), sum(v)
+		FROM (SELECT %s AS v FROM %s WHERE %s) matched;
+		`, value, ds.quotedTable(), f.where())
+
+	var (
+		count                int
+		stats                propertyStats
+		minV, maxV, avg, sum sql.NullFloat64
+	)
+	logQuery(ctx, "stats", queryStr, f.args)
+	if err := readDB.QueryRowContext(ctx, queryStr, f.args...).Scan(&count, &stats.Count, &minV, &maxV, &avg, &sum); err != nil {
+		return 0, stats, fmt.Errorf("error scanning row: %w", err)
+	}
+	stats.Min, stats.Max, stats.Avg, stats.Sum = nullFloat(minV), nullFloat(maxV), nullFloat(avg), nullFloat(sum)
+	return count, stats, nil
+}
+
+// nullFloat converts a nullable float to a pointer, nil for NULL.
+func nullFloat(v sql.NullFloat64) *float64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Float64
+}
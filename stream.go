@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+)
+
+// defaultStreamThreshold is the limit above which searches are streamed row
+// by row instead of aggregated into one string (STREAM_THRESHOLD overrides it).
+const defaultStreamThreshold = 100
+
+var streamThreshold = defaultStreamThreshold
+
+// shouldStream reports whether a search takes the streaming path. Features
+// that are post-processed in Go (formatted, opening hours) or may be re-queried
+// (auto_expand) need the whole result and stay on the buffered path.
+func shouldStream(p searchParams) bool {
+	return p.Limit > streamThreshold && !p.Formatted && !p.AutoExpand && !p.openStatus() && p.Format != formatPBF && p.Format != formatTopoJSON && p.GroupBy == ""
+}
+
+// prefixWriter writes prefix before the first byte of the body, so nothing is
+// sent until the query produced its first row and errors before that point
+// can still become a proper error response.
+type prefixWriter struct {
+	w       io.Writer
+	prefix  string
+	started bool
+}
+
+func (pw *prefixWriter) Write(b []byte) (int, error) {
+	if !pw.started {
+		pw.started = true
+		if _, err := io.WriteString(pw.w, pw.prefix); err != nil {
+			return 0, err
+		}
+	}
+	return pw.w.Write(b)
+}
+
+// streamSearchResponse serves a search through streamSearchResults. The
+// response has no Content-Length or content hash, since neither is known
+// before the last row.
+func streamSearchResponse(w http.ResponseWriter, r *http.Request, params searchParams) {
+	contentType, prefix, suffix := searchEnvelope(r, params)
+	w.Header().Set("Content-Type", contentType)
+
+	// The features array brackets are ours; streamSearchResults only writes the elements
+	pw := &prefixWriter{w: w, prefix: prefix + "["}
+	count, capped, err := streamSearchResults(r.Context(), params, pw)
+	switch {
+	case err != nil && pw.started:
+		// Part of the body is out; dropping the connection is the only way to signal the truncation
+		requestLogger(r.Context()).Error("search stream interrupted", "sent", count, "error", err)
+		panic(http.ErrAbortHandler)
+	case errors.Is(err, errPoolExhausted):
+		w.Header().Set("Retry-After", "1")
+		writeAPIError(w, &apiError{
+			Status:  http.StatusServiceUnavailable,
+			Code:    codePoolExhausted,
+			Message: "no database connection available, please retry shortly",
+		})
+		return
+	case err != nil:
+		writeAPIError(w, queryError(r.Context(), err))
+		return
+	}
+
+	setResultCount(r, count)
+	setResultCapped(r, capped)
+	if count == 0 && params.EmptyNotFound {
+		// Nothing was sent yet, so the 404 can still replace the empty body
+		writeAPIError(w, noResultsError(params))
+		return
+	}
+	if !pw.started {
+		io.WriteString(w, pw.prefix)
+	}
+	io.WriteString(w, "]"+suffix(searchResult{Count: count, Capped: capped, RadiusMeters: params.RadiusMeters}))
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// defaultSummaryRefreshInterval is used when SUMMARY_REFRESH_INTERVAL is unset.
+const defaultSummaryRefreshInterval = 5 * time.Minute
+
+// datasetSummary is cached metadata about a dataset, so metadata endpoints
+// never have to scan the feature table on the request path.
+type datasetSummary struct {
+	Key         string      `json:"key"`
+	DisplayName string      `json:"display_name"`
+	Count       int64       `json:"count"`
+	NullGeoms   int64       `json:"null_geometries"`           // rows skipped by every spatial query
+	Extent      *[4]float64 `json:"extent"`                    // [minLng, minLat, maxLng, maxLat], null when empty
+	UpdatedAt   *time.Time  `json:"data_updated_at,omitempty"` // max(updated_at_column), when configured
+	RefreshedAt time.Time   `json:"refreshed_at"`
+}
+
+// summaries holds the latest datasetSummary per dataset key.
+var summaries = struct {
+	sync.RWMutex
+	byKey map[string]datasetSummary
+}{byKey: map[string]datasetSummary{}}
+
+// refreshSummaries recomputes the summary of every registered dataset and
+// drops the summaries of datasets removed by a reload.
+// A failing dataset keeps its previous summary; the first error is returned.
+func refreshSummaries() error {
+	reg := currentDatasets()
+	summaries.Lock()
+	for key := range summaries.byKey {
+		if _, ok := reg.byKey[key]; !ok {
+			delete(summaries.byKey, key)
+		}
+	}
+	summaries.Unlock()
+
+	var firstErr error
+	for key, ds := range reg.byKey {
+		s, err := loadDatasetSummary(ds)
+		if err != nil {
+			log.Printf("Summary refresh failed for dataset %q: %v", key, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		summaries.Lock()
+		summaries.byKey[key] = s
+		summaries.Unlock()
+	}
+	return firstErr
+}
+
+// loadDatasetSummary computes the feature count and extent of a dataset.
+// The aggregates scan the whole table, so they run without statementTimeout.
+func loadDatasetSummary(ds *dataset) (datasetSummary, error) {
+	updatedExpr := "NULL::timestamptz"
+	if ds.UpdatedColumn != "" {
+		updatedExpr = fmt.Sprintf("max(%s)::timestamptz", pq.QuoteIdentifier(ds.UpdatedColumn))
+	}
+	queryStr := fmt.Sprintf(
+		`SELECT n, nulls, updated, ST_XMin(ext), ST_YMin(ext), ST_XMax(ext), ST_YMax(ext)
+		FROM (
+			SELECT count(*) AS n, count(*) FILTER (WHERE %s IS NULL) AS nulls, %s AS updated, ST_Extent(%s) AS ext
+			FROM %s
+		) s;
+		`, ds.geomCol(), updatedExpr, ds.geom(), ds.quotedTable())
+
+	var (
+		s                              = datasetSummary{Key: ds.Key, DisplayName: ds.DisplayName}
+		updated                        sql.NullTime
+		minLng, minLat, maxLng, maxLat sql.NullFloat64
+	)
+	tx, err := readDB.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return s, fmt.Errorf("starting summary transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(noStatementTimeout); err != nil {
+		return s, fmt.Errorf("lifting statement timeout: %w", err)
+	}
+	err = tx.QueryRow(queryStr).Scan(&s.Count, &s.NullGeoms, &updated, &minLng, &minLat, &maxLng, &maxLat)
+	if err != nil {
+		return s, fmt.Errorf("error scanning summary: %w", err)
+	}
+	if s.NullGeoms > 0 {
+		log.Printf("WARNING: dataset %q has %d rows with a NULL %s, they are skipped by searches", ds.Key, s.NullGeoms, ds.GeomColumn)
+	}
+	if updated.Valid {
+		t := updated.Time.UTC()
+		s.UpdatedAt = &t
+	}
+	if minLng.Valid {
+		s.Extent = &[4]float64{minLng.Float64, minLat.Float64, maxLng.Float64, maxLat.Float64}
+	}
+	s.RefreshedAt = time.Now().UTC()
+	return s, nil
+}
+
+// startSummaryRefresher loads the summaries once and then refreshes them on
+// every tick of interval in the background.
+func startSummaryRefresher(interval time.Duration) {
+	refreshSummaries()
+	go func() {
+		for range time.Tick(interval) {
+			refreshSummaries()
+		}
+	}()
+}
+
+// dataUpdatedAt returns the cached data freshness timestamp of a dataset.
+func dataUpdatedAt(key string) (time.Time, bool) {
+	summaries.RLock()
+	defer summaries.RUnlock()
+
+	s, ok := summaries.byKey[key]
+	if !ok || s.UpdatedAt == nil {
+		return time.Time{}, false
+	}
+	return *s.UpdatedAt, true
+}
+
+// cachedSummaries returns the cached summaries sorted by dataset key.
+func cachedSummaries() []datasetSummary {
+	summaries.RLock()
+	defer summaries.RUnlock()
+
+	list := make([]datasetSummary, 0, len(summaries.byKey))
+	for _, s := range summaries.byKey {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list
+}
+
+// apiDatasetsHandler serves /api/datasets from the cached summaries.
+func apiDatasetsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", contentTypeJSON)
+
+	// Private datasets are only listed for clients that may read them
+	reg := currentDatasets()
+	list := cachedSummaries()
+	visible := list[:0]
+	for _, s := range list {
+		if ds, ok := reg.byKey[s.Key]; ok && canAccess(r, ds) {
+			visible = append(visible, s)
+		}
+	}
+	writeJSON(w, struct {
+		Status   string           `json:"status"`
+		Datasets []datasetSummary `json:"datasets"`
+	}{"ok", visible})
+}
+
+// adminRefreshHandler serves /admin/refresh, recomputing the summaries on demand.
+func adminRefreshHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+
+	if err := refreshSummaries(); err != nil {
+		writeAPIError(w, &apiError{
+			Status:  http.StatusInternalServerError,
+			Code:    codeInternalError,
+			Message: fmt.Sprintf("summary refresh failed: %s", err),
+		})
+		return
+	}
+	writeJSON(w, struct {
+		Status   string           `json:"status"`
+		Datasets []datasetSummary `json:"datasets"`
+	}{"ok", cachedSummaries()})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// Tests that need PostGIS run against TEST_DATABASE_URL (a lib/pq DSN or
+// URL of a database with the postgis extension, where they may create and
+// drop tables) and are skipped without it.
+
+// testDB points db and readDB at the test database for the duration of t.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set, skipping PostGIS test")
+	}
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+	if err := conn.Ping(); err != nil {
+		t.Fatalf("connecting to test database: %v", err)
+	}
+
+	prevDB, prevReadDB := db, readDB
+	db, readDB = conn, conn
+	t.Cleanup(func() {
+		db, readDB = prevDB, prevReadDB
+		conn.Close()
+	})
+	return conn
+}
+
+// testFixture is a table of features for a test dataset: each row is a name
+// and the WKT of its geometry in WGS84, "" for a NULL geometry.
+type testFixture struct {
+	Key          string
+	GeometryType string // as in the dataset descriptor, point when empty
+	Rows         [][2]string
+}
+
+// installFixture creates the fixture table and installs a registry with a
+// validated dataset for it as the only (and default) dataset. The table and
+// the previous registry are restored when t ends.
+func installFixture(t *testing.T, conn *sql.DB, fx testFixture) *dataset {
+	t.Helper()
+	table := "test_" + fx.Key
+	if _, err := conn.Exec(fmt.Sprintf(
+		`DROP TABLE IF EXISTS %[1]s;
+		CREATE TABLE %[1]s (ogc_fid serial PRIMARY KEY, name text, wkb_geometry geometry(Geometry, 4326))`,
+		pq.QuoteIdentifier(table))); err != nil {
+		t.Fatalf("creating fixture table: %v", err)
+	}
+	t.Cleanup(func() { conn.Exec("DROP TABLE IF EXISTS " + pq.QuoteIdentifier(table)) })
+
+	for _, row := range fx.Rows {
+		if _, err := conn.Exec(fmt.Sprintf(
+			`INSERT INTO %s (name, wkb_geometry) VALUES ($1, ST_GeomFromText(NULLIF($2, ''), 4326))`,
+			pq.QuoteIdentifier(table)), row[0], row[1]); err != nil {
+			t.Fatalf("inserting fixture row %q: %v", row[0], err)
+		}
+	}
+
+	descriptor, _ := json.Marshal([]map[string]any{{
+		"key":           fx.Key,
+		"table":         table,
+		"srid":          4326,
+		"geometry_type": fx.GeometryType,
+	}})
+	path := filepath.Join(t.TempDir(), "datasets.json")
+	if err := os.WriteFile(path, descriptor, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reg, err := loadDatasets(path)
+	if err != nil {
+		t.Fatalf("loading fixture dataset: %v", err)
+	}
+	if err := validateDatasets(reg); err != nil {
+		t.Fatalf("validating fixture dataset: %v", err)
+	}
+
+	prev := registry.Swap(reg)
+	t.Cleanup(func() { registry.Store(prev) })
+	return reg.def
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxTileZoom is the deepest zoom level accepted by the tile endpoints.
+const maxTileZoom = 22
+
+// maxTileFeatures caps how many features a single tile request may return.
+const maxTileFeatures = 5000
+
+// tileID identifies an XYZ tile.
+type tileID struct {
+	Z, X, Y int
+}
+
+// tileBounds is the WGS84 (EPSG:4326) extent of an XYZ tile.
+type tileBounds struct {
+	MinLng, MinLat, MaxLng, MaxLat float64
+}
+
+// crossesAntimeridian reports whether b wraps past 180° (MinLng > MaxLng).
+// Tiles never do, user supplied viewports can.
+func (b tileBounds) crossesAntimeridian() bool {
+	return b.MinLng > b.MaxLng
+}
+
+// split returns b as envelopes ST_MakeEnvelope can represent: b itself, or
+// its eastern and western halves when it crosses the antimeridian.
+func (b tileBounds) split() []tileBounds {
+	if !b.crossesAntimeridian() {
+		return []tileBounds{b}
+	}
+	east, west := b, b
+	east.MaxLng, west.MinLng = 180, -180
+	return []tileBounds{east, west}
+}
+
+// center returns the middle of b, across the antimeridian when b wraps.
+func (b tileBounds) center() (lat, lng float64) {
+	maxLng := b.MaxLng
+	if b.crossesAntimeridian() {
+		maxLng += 360
+	}
+	lng = (b.MinLng + maxLng) / 2
+	if lng > 180 {
+		lng -= 360
+	}
+	return (b.MinLat + b.MaxLat) / 2, lng
+}
+
+// tileEnvelope converts XYZ (slippy map) tile coordinates into a WGS84 extent.
+// All tile-based endpoints go through this so they agree on tile boundaries.
+func tileEnvelope(z, x, y int) (tileBounds, error) {
+	if z < 0 || z > maxTileZoom {
+		return tileBounds{}, fmt.Errorf("zoom %d is out of range [0, %d]", z, maxTileZoom)
+	}
+	n := 1 << uint(z)
+	if x < 0 || x >= n || y < 0 || y >= n {
+		return tileBounds{}, fmt.Errorf("tile %d/%d/%d does not exist at zoom %d", z, x, y, z)
+	}
+
+	tileLng := func(x int) float64 { return float64(x)/float64(n)*360 - 180 }
+	tileLat := func(y int) float64 {
+		return math.Atan(math.Sinh(math.Pi*(1-2*float64(y)/float64(n)))) * 180 / math.Pi
+	}
+
+	// Tile rows grow southwards, so y+1 is the southern edge.
+	return tileBounds{
+		MinLng: tileLng(x),
+		MinLat: tileLat(y + 1),
+		MaxLng: tileLng(x + 1),
+		MaxLat: tileLat(y),
+	}, nil
+}
+
+// parseTilePath reads the {z}/{x}/{y} path values, stripping the expected
+// file extension from {y} (e.g. "12.geojson").
+func parseTilePath(r *http.Request, ext string) (tileID, tileBounds, *apiError) {
+	yStr := r.PathValue("y")
+	if !strings.HasSuffix(yStr, ext) {
+		return tileID{}, tileBounds{}, &apiError{Status: http.StatusNotFound, Code: codeInvalidTile, Message: fmt.Sprintf("tile path must end in %s", ext)}
+	}
+	yStr = strings.TrimSuffix(yStr, ext)
+
+	z, errZ := strconv.Atoi(r.PathValue("z"))
+	x, errX := strconv.Atoi(r.PathValue("x"))
+	y, errY := strconv.Atoi(yStr)
+	if errZ != nil || errX != nil || errY != nil {
+		return tileID{}, tileBounds{}, badRequest(codeInvalidTile, "tile coordinates must be integers")
+	}
+
+	b, err := tileEnvelope(z, x, y)
+	if err != nil {
+		return tileID{}, tileBounds{}, badRequest(codeInvalidTile, "%s", err)
+	}
+	return tileID{Z: z, X: x, Y: y}, b, nil
+}
+
+// apiTileGeoJSONHandler serves /api/tile/{z}/{x}/{y}.geojson: the raw features
+// intersecting a tile as a FeatureCollection, handy for inspecting tile contents.
+func apiTileGeoJSONHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", contentTypeGeoJSON)
+
+	_, bounds, apiErr := parseTilePath(r, ".geojson")
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	ds, apiErr := lookupDataset(r.URL.Query().Get("dataset"))
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	featureCollection, err := getTileGeoJSONFromDatabase(r.Context(), ds, bounds)
+	if err != nil {
+		writeAPIError(w, queryError(r.Context(), err))
+		return
+	}
+
+	writeBody(w, featureCollection)
+}
+
+// getTileGeoJSONFromDatabase returns a GeoJSON FeatureCollection of the features
+// whose geometry intersects the given tile extent.
+func getTileGeoJSONFromDatabase(ctx context.Context, ds *dataset, b tileBounds) (string, error) {
+	envelope := ds.nativeEnvelope("$1", "$2", "$3", "$4")
+	var queryStr = fmt.Sprintf(
+		`SELECT jsonb_build_object(
+			'type', 'FeatureCollection',
+			'features', COALESCE(jsonb_agg(jsonb_build_object(
+				'type', 'Feature',
+				'geometry', ST_AsGeoJSON(%s)::jsonb,
+				'properties', %s
+			)), '[]'::jsonb)
+		)
+		FROM (
+			SELECT *
+			FROM %s
+			-- && uses the spatial index before the exact intersection test
+			WHERE %s && %s
+				AND ST_Intersects(%s, %s)
+			LIMIT %d
+		) row;
+		`, ds.geom(), ds.propertiesExpr("row"), ds.quotedTable(),
+		ds.geomCol(), envelope, ds.geomCol(), envelope, maxTileFeatures)
+
+	row := readDB.QueryRowContext(ctx, queryStr, b.MinLng, b.MinLat, b.MaxLng, b.MaxLat)
+
+	var featureCollection string
+	err := row.Scan(&featureCollection)
+	if err == sql.ErrNoRows {
+		return `{"type": "FeatureCollection", "features": []}`, nil
+	} else if err != nil {
+		return "", fmt.Errorf("error scanning row: %w", err)
+	}
+
+	return featureCollection, nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TopoJSON has no registered media type; the spec and common clients
+// (topojson-client, d3) expect plain JSON.
+const contentTypeTopoJSON = contentTypeJSON
+
+// topoPoint is one position; TopoJSON output keeps only x and y.
+type topoPoint [2]float64
+
+// topoGeometry is a GeoJSON geometry as decoded for the TopoJSON encoder.
+type topoGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometries  []topoGeometry  `json:"geometries"`
+}
+
+// topoFeature is the row shape of a format=topojson search (a plain GeoJSON Feature).
+type topoFeature struct {
+	ID         json.RawMessage `json:"id,omitempty"`
+	Geometry   *topoGeometry   `json:"geometry"`
+	Properties json.RawMessage `json:"properties,omitempty"`
+}
+
+// topoBuilder collects the lines and rings of every geometry, finds the
+// junctions where they meet, and cuts them into shared arcs.
+type topoBuilder struct {
+	lines []*topoLine
+	seen  map[topoPoint]*topoVisit
+
+	arcs    [][]topoPoint
+	arcKeys map[string]int
+}
+
+// topoLine is a LineString or polygon ring awaiting its arc indexes.
+type topoLine struct {
+	points []topoPoint // rings without the closing duplicate of the first point
+	ring   bool
+	arcs   []int // filled in by cut
+}
+
+// topoVisit records the neighbors a point was first seen with. A point seen
+// again with other neighbors is a junction: lines meet or part there.
+type topoVisit struct {
+	prev, next topoPoint
+	junction   bool
+}
+
+// encodeSearchTopoJSON converts the GeoJSON features of a format=topojson
+// search into a Topology with a single GeometryCollection object named after
+// the dataset. Borders shared by adjacent polygons become one arc referenced
+// from both sides, which is where the size win over GeoJSON comes from.
+// Coordinates are not quantized, so the output is lossless.
+func encodeSearchTopoJSON(features, object string, capped bool) ([]byte, error) {
+	var list []topoFeature
+	if err := json.Unmarshal([]byte(features), &list); err != nil {
+		return nil, fmt.Errorf("decoding features: %w", err)
+	}
+
+	b := &topoBuilder{seen: map[topoPoint]*topoVisit{}, arcKeys: map[string]int{}}
+	geometries := make([]map[string]any, len(list))
+	// Geometries are first converted with pointers into b.lines, whose arcs
+	// are only known once every line has been seen
+	pending := make([]func() map[string]any, len(list))
+	for i, f := range list {
+		build, err := b.add(f.Geometry)
+		if err != nil {
+			return nil, fmt.Errorf("feature %d: %w", i, err)
+		}
+		pending[i] = build
+	}
+	b.cut()
+	for i, f := range list {
+		g := pending[i]()
+		if len(f.ID) > 0 {
+			g["id"] = f.ID
+		}
+		if len(f.Properties) > 0 && string(f.Properties) != "null" {
+			g["properties"] = f.Properties
+		}
+		geometries[i] = g
+	}
+
+	arcs := b.arcs
+	if arcs == nil {
+		arcs = [][]topoPoint{}
+	}
+	return json.Marshal(struct {
+		Type    string                    `json:"type"`
+		Objects map[string]map[string]any `json:"objects"`
+		Arcs    [][]topoPoint             `json:"arcs"`
+		Capped  bool                      `json:"capped"` // foreign member, as in the other search formats
+	}{"Topology", map[string]map[string]any{object: {"type": "GeometryCollection", "geometries": geometries}}, arcs, capped})
+}
+
+// add registers the lines of g and returns a function building its TopoJSON
+// geometry once the arcs are cut.
+func (b *topoBuilder) add(g *topoGeometry) (func() map[string]any, error) {
+	if g == nil {
+		return func() map[string]any { return map[string]any{"type": nil} }, nil
+	}
+	switch g.Type {
+	case "Point", "MultiPoint":
+		// Points have no arcs and keep their coordinates
+		var coords any
+		if err := json.Unmarshal(g.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", g.Type, err)
+		}
+		return func() map[string]any { return map[string]any{"type": g.Type, "coordinates": coords} }, nil
+	case "LineString":
+		var coords [][]float64
+		if err := json.Unmarshal(g.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", g.Type, err)
+		}
+		l := b.addLine(coords, false)
+		return func() map[string]any { return map[string]any{"type": g.Type, "arcs": l.arcs} }, nil
+	case "MultiLineString", "Polygon":
+		var coords [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", g.Type, err)
+		}
+		lines := b.addLines(coords, g.Type == "Polygon")
+		return func() map[string]any { return map[string]any{"type": g.Type, "arcs": lineArcs(lines)} }, nil
+	case "MultiPolygon":
+		var coords [][][][]float64
+		if err := json.Unmarshal(g.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", g.Type, err)
+		}
+		polygons := make([][]*topoLine, len(coords))
+		for i, rings := range coords {
+			polygons[i] = b.addLines(rings, true)
+		}
+		return func() map[string]any {
+			arcs := make([][][]int, len(polygons))
+			for i, rings := range polygons {
+				arcs[i] = lineArcs(rings)
+			}
+			return map[string]any{"type": g.Type, "arcs": arcs}
+		}, nil
+	case "GeometryCollection":
+		builds := make([]func() map[string]any, len(g.Geometries))
+		for i := range g.Geometries {
+			build, err := b.add(&g.Geometries[i])
+			if err != nil {
+				return nil, err
+			}
+			builds[i] = build
+		}
+		return func() map[string]any {
+			members := make([]map[string]any, len(builds))
+			for i, build := range builds {
+				members[i] = build()
+			}
+			return map[string]any{"type": g.Type, "geometries": members}
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported geometry type %q", g.Type)
+	}
+}
+
+// lineArcs lists the arc indexes of each line.
+func lineArcs(lines []*topoLine) [][]int {
+	arcs := make([][]int, len(lines))
+	for i, l := range lines {
+		arcs[i] = l.arcs
+	}
+	return arcs
+}
+
+// addLines registers several lines (or rings).
+func (b *topoBuilder) addLines(coords [][][]float64, ring bool) []*topoLine {
+	lines := make([]*topoLine, len(coords))
+	for i, c := range coords {
+		lines[i] = b.addLine(c, ring)
+	}
+	return lines
+}
+
+// addLine registers one line and marks its points' neighbors. The ends of a
+// LineString are always junctions, arcs cannot continue past them.
+func (b *topoBuilder) addLine(coords [][]float64, ring bool) *topoLine {
+	l := &topoLine{ring: ring}
+	for _, c := range coords {
+		if len(c) >= 2 {
+			l.points = append(l.points, topoPoint{c[0], c[1]})
+		}
+	}
+	if ring && len(l.points) > 1 && l.points[0] == l.points[len(l.points)-1] {
+		l.points = l.points[:len(l.points)-1]
+	}
+	b.lines = append(b.lines, l)
+
+	n := len(l.points)
+	for i, p := range l.points {
+		var prev, next topoPoint
+		switch {
+		case ring:
+			prev, next = l.points[(i+n-1)%n], l.points[(i+1)%n]
+		case i == 0 || i == n-1:
+			b.visit(p, p, p).junction = true
+			continue
+		default:
+			prev, next = l.points[i-1], l.points[i+1]
+		}
+		b.visit(p, prev, next)
+	}
+	return l
+}
+
+// visit records p with its neighbors, flagging a junction when they differ
+// from the first visit in either direction.
+func (b *topoBuilder) visit(p, prev, next topoPoint) *topoVisit {
+	v, ok := b.seen[p]
+	if !ok {
+		v = &topoVisit{prev: prev, next: next}
+		b.seen[p] = v
+	} else if !(v.prev == prev && v.next == next) && !(v.prev == next && v.next == prev) {
+		v.junction = true
+	}
+	return v
+}
+
+// cut splits every line at its junctions and assigns the arc indexes.
+func (b *topoBuilder) cut() {
+	for _, l := range b.lines {
+		if len(l.points) == 0 {
+			continue
+		}
+		points := l.points
+		if l.ring {
+			// Start the ring at a junction so no arc spans one; a ring
+			// without junctions starts at its smallest point, so equal
+			// rings produce equal arcs
+			start := -1
+			for i, p := range points {
+				if b.seen[p].junction {
+					start = i
+					break
+				}
+			}
+			if start < 0 {
+				start = 0
+				for i, p := range points {
+					if p[0] < points[start][0] || (p[0] == points[start][0] && p[1] < points[start][1]) {
+						start = i
+					}
+				}
+			}
+			rotated := make([]topoPoint, 0, len(points)+1)
+			rotated = append(rotated, points[start:]...)
+			rotated = append(rotated, points[:start]...)
+			points = append(rotated, rotated[0])
+		}
+
+		from := 0
+		for i := 1; i < len(points); i++ {
+			if i == len(points)-1 || b.seen[points[i]].junction {
+				l.arcs = append(l.arcs, b.arc(points[from:i+1]))
+				from = i
+			}
+		}
+		if len(points) == 1 {
+			l.arcs = append(l.arcs, b.arc(points))
+		}
+	}
+}
+
+// arc returns the index of the arc with the given points, reusing an equal
+// arc (as i) or its reverse (as ^i, the TopoJSON one's complement).
+func (b *topoBuilder) arc(points []topoPoint) int {
+	key := topoKey(points, false)
+	if i, ok := b.arcKeys[key]; ok {
+		return i
+	}
+	if i, ok := b.arcKeys[topoKey(points, true)]; ok {
+		return ^i
+	}
+	i := len(b.arcs)
+	b.arcs = append(b.arcs, append([]topoPoint(nil), points...))
+	b.arcKeys[key] = i
+	return i
+}
+
+// topoKey serializes points, optionally in reverse order, for arc lookup.
+func topoKey(points []topoPoint, reverse bool) string {
+	var sb strings.Builder
+	for i := range points {
+		p := points[i]
+		if reverse {
+			p = points[len(points)-1-i]
+		}
+		sb.WriteString(strconv.FormatFloat(p[0], 'g', -1, 64))
+		sb.WriteByte(',')
+		sb.WriteString(strconv.FormatFloat(p[1], 'g', -1, 64))
+		sb.WriteByte(';')
+	}
+	return sb.String()
+}
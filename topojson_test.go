@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// topoTopology is the decoded shape of encodeSearchTopoJSON output.
+type topoTopology struct {
+	Type    string `json:"type"`
+	Objects map[string]struct {
+		Geometries []struct {
+			Type       string          `json:"type"`
+			ID         json.RawMessage `json:"id"`
+			Arcs       json.RawMessage `json:"arcs"`
+			Properties json.RawMessage `json:"properties"`
+		} `json:"geometries"`
+	} `json:"objects"`
+	Arcs   [][]topoPoint `json:"arcs"`
+	Capped bool          `json:"capped"`
+}
+
+// decodeLine stitches the arcs of a line back into its points: a negative
+// index ^i is arc i reversed, and consecutive arcs share their end points.
+func (topo topoTopology) decodeLine(t *testing.T, arcs []int) []topoPoint {
+	t.Helper()
+	var points []topoPoint
+	for _, i := range arcs {
+		reverse := i < 0
+		if reverse {
+			i = ^i
+		}
+		if i >= len(topo.Arcs) {
+			t.Fatalf("arc %d out of %d", i, len(topo.Arcs))
+		}
+		arc := slices.Clone(topo.Arcs[i])
+		if reverse {
+			slices.Reverse(arc)
+		}
+		if len(points) > 0 {
+			if points[len(points)-1] != arc[0] {
+				t.Fatalf("arcs %v do not connect at %v", arcs, arc[0])
+			}
+			arc = arc[1:]
+		}
+		points = append(points, arc...)
+	}
+	return points
+}
+
+// sameRing reports whether two closed rings have the same points in the same
+// order, whichever point they start at.
+func sameRing(a, b []topoPoint) bool {
+	if len(a) != len(b) || len(a) < 2 || a[0] != a[len(a)-1] || b[0] != b[len(b)-1] {
+		return false
+	}
+	a, b = a[:len(a)-1], b[:len(b)-1]
+	for shift := range a {
+		if slices.Equal(append(slices.Clone(a[shift:]), a[:shift]...), b) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEncodeSearchTopoJSON(t *testing.T) {
+	// Two unit squares sharing the x=1 edge, which each walks in the other
+	// direction, a triangle touching nothing, a square with a hole and a line
+	// ending on the shared edge
+	west := [][]topoPoint{{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}}
+	east := [][]topoPoint{{{1, 0}, {2, 0}, {2, 1}, {1, 1}, {1, 0}}}
+	island := [][]topoPoint{{{5, 5}, {6, 5}, {6, 6}, {5, 5}}}
+	holed := [][]topoPoint{
+		{{10, 10}, {14, 10}, {14, 14}, {10, 14}, {10, 10}},
+		{{11, 11}, {11, 12}, {12, 12}, {12, 11}, {11, 11}},
+	}
+	line := []topoPoint{{1, 1}, {1.5, 2}, {3, 2}}
+	polygon := func(id int, rings [][]topoPoint) map[string]any {
+		return map[string]any{"type": "Feature", "id": id,
+			"geometry":   map[string]any{"type": "Polygon", "coordinates": rings},
+			"properties": map[string]any{"n": id}}
+	}
+	features, _ := json.Marshal([]map[string]any{
+		polygon(1, west), polygon(2, east), polygon(3, island), polygon(4, holed),
+		{"type": "Feature", "id": 5, "geometry": map[string]any{"type": "LineString", "coordinates": line}},
+		{"type": "Feature", "id": 6, "geometry": map[string]any{"type": "Point", "coordinates": []float64{7, 8}}},
+	})
+
+	out, err := encodeSearchTopoJSON(string(features), "zones", true)
+	if err != nil {
+		t.Fatalf("encodeSearchTopoJSON: %v", err)
+	}
+	var topo topoTopology
+	if err := json.Unmarshal(out, &topo); err != nil {
+		t.Fatalf("decoding %s: %v", out, err)
+	}
+	if topo.Type != "Topology" || !topo.Capped || len(topo.Objects["zones"].Geometries) != 6 {
+		t.Fatalf("unexpected topology %s", out)
+	}
+	geoms := topo.Objects["zones"].Geometries
+
+	ringArcs := make([][][]int, 4)
+	for i, want := range [][][]topoPoint{west, east, island, holed} {
+		g := geoms[i]
+		if g.Type != "Polygon" || string(g.ID) != strconv.Itoa(i+1) {
+			t.Fatalf("geometry %d is a %s with id %s", i, g.Type, g.ID)
+		}
+		if err := json.Unmarshal(g.Arcs, &ringArcs[i]); err != nil {
+			t.Fatalf("geometry %d arcs %s: %v", i, g.Arcs, err)
+		}
+		if len(ringArcs[i]) != len(want) {
+			t.Fatalf("geometry %d has %d rings, want %d", i, len(ringArcs[i]), len(want))
+		}
+		for r := range want {
+			if got := topo.decodeLine(t, ringArcs[i][r]); !sameRing(got, want[r]) {
+				t.Errorf("geometry %d ring %d decodes to %v, want %v", i, r, got, want[r])
+			}
+		}
+	}
+
+	// The shared edge is stored once, forward from one square and reversed
+	// (^i) from the other
+	var shared int
+	found := false
+	for _, a := range ringArcs[0][0] {
+		for _, b := range ringArcs[1][0] {
+			if a == ^b {
+				shared, found = a, true
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("the squares share no arc: %v and %v", ringArcs[0], ringArcs[1])
+	}
+	if a := topo.Arcs[max(shared, ^shared)]; len(a) != 2 {
+		t.Errorf("shared arc %v, want just the x=1 edge", a)
+	}
+	// 2 arcs for the west square, 1 more for the east one, 1 per other
+	// ring, and the line (which ends on a corner, making no new junction)
+	if len(topo.Arcs) != 2+1+1+2+1 {
+		t.Errorf("%d arcs, want 7: %v", len(topo.Arcs), topo.Arcs)
+	}
+
+	var lineArcs []int
+	if err := json.Unmarshal(geoms[4].Arcs, &lineArcs); err != nil {
+		t.Fatal(err)
+	}
+	if got := topo.decodeLine(t, lineArcs); !slices.Equal(got, line) {
+		t.Errorf("line decodes to %v, want %v", got, line)
+	}
+	if geoms[5].Type != "Point" || geoms[5].Arcs != nil {
+		t.Errorf("point encoded as %s with arcs %s", geoms[5].Type, geoms[5].Arcs)
+	}
+	if string(geoms[0].Properties) != `{"n":1}` || geoms[4].Properties != nil {
+		t.Errorf("properties %s and %s, want {\"n\":1} and none", geoms[0].Properties, geoms[4].Properties)
+	}
+}
+
+func TestEncodeSearchTopoJSONEmpty(t *testing.T) {
+	out, err := encodeSearchTopoJSON(`[]`, "zones", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"type":"Topology","objects":{"zones":{"geometries":[],"type":"GeometryCollection"}},"arcs":[],"capped":false}`; string(out) != want {
+		t.Errorf("empty result encoded to %s, want %s", out, want)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/maphash"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Usage accounting: request counts per API key, or per client IP for
+// anonymous requests, over a fixed window (USAGE_WINDOW). It is the data
+// source for future quotas and billing, so it only counts, cheaply: the
+// counters are split over usageShards maps with their own locks.
+const (
+	defaultUsageWindow = time.Hour
+	usageShards        = 16
+	// maxUsageClientsPerShard bounds memory under a flood of distinct IPs;
+	// clients beyond it are counted together as usageOverflowClient
+	maxUsageClientsPerShard = 4096
+	usageOverflowClient     = "ip:other"
+)
+
+// usageCount is the usage of one client in the current window.
+type usageCount struct {
+	Client   string `json:"client"`
+	Requests int64  `json:"requests"`
+	Errors   int64  `json:"errors"` // responses with status >= 400
+}
+
+type usageShard struct {
+	sync.Mutex
+	counts map[string]*usageCount
+}
+
+// usage holds the current window's counters and the last completed window.
+var usage struct {
+	shards [usageShards]usageShard
+	seed   maphash.Seed
+
+	mu          sync.Mutex // guards the window fields below
+	window      time.Duration
+	windowStart time.Time
+	previous    *usageWindow
+}
+
+// usageWindow is a summary of one accounting window, as served by /admin/usage.
+type usageWindow struct {
+	Start   time.Time    `json:"start"`
+	End     *time.Time   `json:"end,omitempty"` // nil for the current window
+	Clients []usageCount `json:"clients"`       // most requests first
+}
+
+// startUsageAccounting initializes the counters and resets them every window.
+func startUsageAccounting(window time.Duration) {
+	usage.seed = maphash.MakeSeed()
+	for i := range usage.shards {
+		usage.shards[i].counts = map[string]*usageCount{}
+	}
+	usage.mu.Lock()
+	usage.window, usage.windowStart = window, time.Now()
+	usage.mu.Unlock()
+
+	go func() {
+		for range time.Tick(window) {
+			rotateUsageWindow()
+		}
+	}()
+}
+
+// rotateUsageWindow closes the current window, keeping its summary as the
+// previous one, and starts counting from zero.
+func rotateUsageWindow() {
+	usage.mu.Lock()
+	defer usage.mu.Unlock()
+
+	now := time.Now()
+	clients := []usageCount{}
+	for i := range usage.shards {
+		s := &usage.shards[i]
+		s.Lock()
+		for _, c := range s.counts {
+			clients = append(clients, *c)
+		}
+		s.counts = map[string]*usageCount{}
+		s.Unlock()
+	}
+	sortUsage(clients)
+	usage.previous = &usageWindow{Start: usage.windowStart, End: &now, Clients: clients}
+	usage.windowStart = now
+}
+
+// recordUsage counts one API request of r with the given response status.
+// startUsageAccounting must have run before the server accepts requests.
+func recordUsage(r *http.Request, status int) {
+	if !accountedPath(r.URL.Path) {
+		return
+	}
+	client := usageClient(r)
+	s := &usage.shards[maphash.String(usage.seed, client)%usageShards]
+	s.Lock()
+	c, ok := s.counts[client]
+	if !ok {
+		if len(s.counts) >= maxUsageClientsPerShard {
+			client = usageOverflowClient
+			c = s.counts[client]
+		}
+		if c == nil {
+			c = &usageCount{Client: client}
+			s.counts[client] = c
+		}
+	}
+	c.Requests++
+	if status >= http.StatusBadRequest {
+		c.Errors++
+	}
+	s.Unlock()
+}
+
+// accountedPath reports whether requests to path count towards usage: the
+// API and tiles, not the SPA, probes or admin calls.
+func accountedPath(path string) bool {
+	return strings.HasPrefix(path, "/api/") || path == "/api" || strings.HasPrefix(path, "/tiles/")
+}
+
+// usageClient identifies the client of r: "key:" and a fingerprint of a valid
+// API key (never the key itself), or "ip:" and the client address.
+func usageClient(r *http.Request) string {
+	if hasValidAPIKey(r) {
+		sum := sha256.Sum256([]byte(r.Header.Get(apiKeyHeader)))
+		return "key:" + hex.EncodeToString(sum[:4])
+	}
+	return "ip:" + clientIP(r)
+}
+
+// clientIP returns the address of the client behind the App Engine / Cloud
+// Run front end, which reports it as the first X-Forwarded-For entry.
+// Good enough for accounting; it must not be used for access control, as
+// clients can prepend entries of their own.
+func clientIP(r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		first, _, _ := strings.Cut(xff, ",")
+		return strings.TrimSpace(first)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// sortUsage orders clients by requests, busiest first, then by name.
+func sortUsage(clients []usageCount) {
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].Requests != clients[j].Requests {
+			return clients[i].Requests > clients[j].Requests
+		}
+		return clients[i].Client < clients[j].Client
+	})
+}
+
+// adminUsageHandler serves /admin/usage: request counts per client in the
+// current window and the last completed one.
+func adminUsageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+
+	usage.mu.Lock()
+	window, start, previous := usage.window, usage.windowStart, usage.previous
+	usage.mu.Unlock()
+
+	clients := []usageCount{}
+	for i := range usage.shards {
+		s := &usage.shards[i]
+		s.Lock()
+		for _, c := range s.counts {
+			clients = append(clients, *c)
+		}
+		s.Unlock()
+	}
+	sortUsage(clients)
+
+	writeJSON(w, struct {
+		Status   string       `json:"status"`
+		Window   string       `json:"window"`
+		Current  usageWindow  `json:"current"`
+		Previous *usageWindow `json:"previous"` // null until the first window has closed
+	}{"ok", window.String(), usageWindow{Start: start, Clients: clients}, previous})
+}